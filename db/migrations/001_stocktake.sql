@@ -0,0 +1,35 @@
+-- Инвентаризация фонда: сверка books.cnt с фактическим наличием на полках
+
+ALTER TABLE books ADD COLUMN IF NOT EXISTS isbn VARCHAR(20);
+ALTER TABLE books ADD COLUMN IF NOT EXISTS shelf VARCHAR(50);
+
+CREATE TABLE IF NOT EXISTS stocktake_sessions (
+    id         SERIAL PRIMARY KEY,
+    type_id    INT REFERENCES book_types(id),
+    shelf_from VARCHAR(50),
+    shelf_to   VARCHAR(50),
+    status     VARCHAR(10) NOT NULL DEFAULT 'open',
+    opened_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+    closed_at  TIMESTAMP,
+    applied    BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS stocktake_counts (
+    session_id INT NOT NULL REFERENCES stocktake_sessions(id) ON DELETE CASCADE,
+    book_id    INT NOT NULL REFERENCES books(id),
+    counted    INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (session_id, book_id)
+);
+
+CREATE TABLE IF NOT EXISTS stocktake_discrepancies (
+    session_id    INT NOT NULL REFERENCES stocktake_sessions(id) ON DELETE CASCADE,
+    book_id       INT NOT NULL REFERENCES books(id),
+    expected      INT NOT NULL,
+    counted       INT NOT NULL,
+    on_loan       INT NOT NULL,
+    missing       INT NOT NULL,
+    unexpected    INT NOT NULL,
+    found_on_loan INT NOT NULL,
+    in_scope      BOOLEAN NOT NULL,
+    PRIMARY KEY (session_id, book_id)
+);
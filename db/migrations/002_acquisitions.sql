@@ -0,0 +1,33 @@
+-- Комплектование: поставщики, фонды, заказы на закупку
+
+CREATE TABLE IF NOT EXISTS vendors (
+    id      SERIAL PRIMARY KEY,
+    name    VARCHAR(255) NOT NULL,
+    contact VARCHAR(255)
+);
+
+CREATE TABLE IF NOT EXISTS funds (
+    id     SERIAL PRIMARY KEY,
+    name   VARCHAR(255) NOT NULL,
+    budget NUMERIC(12, 2) NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS purchase_orders (
+    id         SERIAL PRIMARY KEY,
+    vendor_id  INT NOT NULL REFERENCES vendors(id),
+    fund_id    INT NOT NULL REFERENCES funds(id),
+    status     VARCHAR(20) NOT NULL DEFAULT 'open',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS purchase_order_lines (
+    id       SERIAL PRIMARY KEY,
+    order_id INT NOT NULL REFERENCES purchase_orders(id) ON DELETE CASCADE,
+    book_id  INT REFERENCES books(id),
+    title    VARCHAR(255) NOT NULL,
+    isbn     VARCHAR(20),
+    type_id  INT NOT NULL REFERENCES book_types(id),
+    quantity INT NOT NULL CHECK (quantity > 0),
+    received INT NOT NULL DEFAULT 0 CHECK (received >= 0 AND received <= quantity),
+    price    NUMERIC(12, 2) NOT NULL DEFAULT 0
+);
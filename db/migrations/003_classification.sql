@@ -0,0 +1,18 @@
+-- Предметная классификация (УДК/ББК/Дьюи)
+
+CREATE TABLE IF NOT EXISTS classes (
+    id        SERIAL PRIMARY KEY,
+    scheme    VARCHAR(10) NOT NULL,
+    code      VARCHAR(50) NOT NULL,
+    name      VARCHAR(255) NOT NULL,
+    parent_id INT REFERENCES classes(id),
+    UNIQUE (scheme, code)
+);
+
+CREATE INDEX IF NOT EXISTS classes_parent_idx ON classes(parent_id);
+
+CREATE TABLE IF NOT EXISTS book_classes (
+    book_id  INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    class_id INT NOT NULL REFERENCES classes(id) ON DELETE CASCADE,
+    PRIMARY KEY (book_id, class_id)
+);
@@ -0,0 +1,17 @@
+-- Рекомендации "читатели, бравшие X, также брали Y"
+
+CREATE TABLE IF NOT EXISTS book_cooccurrence (
+    book_id       INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    other_book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    clients       INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (book_id, other_book_id)
+);
+
+-- Последняя обработанная фоновым пересчетом запись журнала
+CREATE TABLE IF NOT EXISTS recommendation_state (
+    id              INT PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    last_journal_id INT NOT NULL DEFAULT 0,
+    refreshed_at    TIMESTAMP
+);
+
+INSERT INTO recommendation_state (id) VALUES (1) ON CONFLICT DO NOTHING;
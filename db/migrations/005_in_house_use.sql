@@ -0,0 +1,16 @@
+-- Издания только для читального зала
+
+ALTER TABLE book_types ADD COLUMN IF NOT EXISTS circulating BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- NULL означает, что режим выдачи наследуется от типа книги
+ALTER TABLE books ADD COLUMN IF NOT EXISTS circulating BOOLEAN;
+
+CREATE TABLE IF NOT EXISTS in_house_uses (
+    id          SERIAL PRIMARY KEY,
+    book_id     INT NOT NULL REFERENCES books(id),
+    client_id   INT REFERENCES clients(id),
+    used_at     TIMESTAMP NOT NULL DEFAULT NOW(),
+    returned_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS in_house_uses_used_at_idx ON in_house_uses(used_at);
@@ -0,0 +1,13 @@
+-- Журнал слияний дублей в каталоге
+
+CREATE TABLE IF NOT EXISTS book_merges (
+    id           SERIAL PRIMARY KEY,
+    survivor_id  INT NOT NULL REFERENCES books(id),
+    merged_ids   INT[] NOT NULL,
+    merged_names TEXT[] NOT NULL,
+    added_cnt    INT NOT NULL,
+    moved_loans  INT NOT NULL,
+    reason       TEXT,
+    merged_by    VARCHAR(255),
+    merged_at    TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,26 @@
+-- Тип документа клиента, нормализация и уникальность
+
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS document_type VARCHAR(20) NOT NULL DEFAULT 'ru_passport';
+
+UPDATE clients SET
+    passport_seria = UPPER(REGEXP_REPLACE(COALESCE(passport_seria, ''), '[\s-]', '', 'g')),
+    passport_number = UPPER(REGEXP_REPLACE(COALESCE(passport_number, ''), '[\s-]', '', 'g'));
+
+-- Документ, уже зарегистрированный за клиентом с меньшим id. Такие записи остались
+-- с прежних времен, не участвуют в уникальности и находятся через /clients/duplicates
+-- для объединения. Флаг снимается, когда клиенту указывают другой документ.
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS document_conflict BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE clients c SET document_conflict = TRUE
+WHERE c.passport_number <> ''
+  AND EXISTS (
+      SELECT 1 FROM clients o
+      WHERE o.id < c.id
+        AND o.document_type = c.document_type
+        AND o.passport_seria = c.passport_seria
+        AND o.passport_number = c.passport_number);
+
+-- Пустые документы старых записей не уникальны
+CREATE UNIQUE INDEX IF NOT EXISTS clients_document_uniq
+    ON clients (document_type, passport_seria, passport_number)
+    WHERE passport_number <> '' AND NOT document_conflict;
@@ -0,0 +1,20 @@
+-- Шифрование паспортных данных клиентов и роли библиотекарей
+
+ALTER TABLE librarians ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'librarian';
+
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS passport_seria_enc BYTEA;
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS passport_number_enc BYTEA;
+-- Ключ данных записи, зашифрованный KEK
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS passport_dek BYTEA;
+-- Слепые индексы: документ целиком (уникальность) и номер (точный поиск)
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS document_bidx BYTEA;
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS passport_number_bidx BYTEA;
+
+-- Открытые значения шифруются при старте сервера и затем очищаются
+ALTER TABLE clients ALTER COLUMN passport_seria DROP NOT NULL;
+ALTER TABLE clients ALTER COLUMN passport_number DROP NOT NULL;
+
+DROP INDEX IF EXISTS clients_document_uniq;
+-- У пустых документов слепого индекса нет (NULL), дубли с флагом document_conflict не проверяются
+CREATE UNIQUE INDEX IF NOT EXISTS clients_document_bidx_uniq ON clients (document_bidx) WHERE NOT document_conflict;
+CREATE INDEX IF NOT EXISTS clients_passport_number_bidx_idx ON clients (passport_number_bidx);
@@ -0,0 +1,15 @@
+-- Читательские билеты
+
+CREATE SEQUENCE IF NOT EXISTS library_card_seq START 1;
+
+CREATE TABLE IF NOT EXISTS library_cards (
+    id          SERIAL PRIMARY KEY,
+    client_id   INT NOT NULL REFERENCES clients(id),
+    card_number VARCHAR(20) NOT NULL UNIQUE,
+    status      VARCHAR(10) NOT NULL DEFAULT 'active',
+    issued_at   TIMESTAMP NOT NULL DEFAULT NOW(),
+    replaced_at TIMESTAMP
+);
+
+-- У клиента не больше одного действующего билета
+CREATE UNIQUE INDEX IF NOT EXISTS library_cards_active_uniq ON library_cards (client_id) WHERE status = 'active';
@@ -0,0 +1,15 @@
+-- Контакты клиентов и настройки уведомлений
+
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS email VARCHAR(255);
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS phone VARCHAR(20);
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS address TEXT;
+-- Предпочитаемые каналы: email, sms, post
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS notification_channels TEXT[] NOT NULL DEFAULT '{}';
+
+-- Явные согласия и отказы; отсутствие строки означает согласие по умолчанию
+CREATE TABLE IF NOT EXISTS client_notification_prefs (
+    client_id         INT NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    notification_type VARCHAR(20) NOT NULL,
+    opt_in            BOOLEAN NOT NULL,
+    PRIMARY KEY (client_id, notification_type)
+);
@@ -0,0 +1,13 @@
+-- Поиск клиентов по ФИО (с учетом ё/е и транслитерации)
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- "фамилия имя отчество" в нижнем регистре с ё, замененной на е, и ее транслитерация.
+-- Заполняются приложением при сохранении клиента и при старте для старых записей.
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS search_name TEXT;
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS search_latin TEXT;
+
+CREATE INDEX IF NOT EXISTS clients_search_name_prefix_idx ON clients (search_name text_pattern_ops);
+CREATE INDEX IF NOT EXISTS clients_search_latin_prefix_idx ON clients (search_latin text_pattern_ops);
+CREATE INDEX IF NOT EXISTS clients_search_name_trgm_idx ON clients USING GIN (search_name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS clients_search_latin_trgm_idx ON clients USING GIN (search_latin gin_trgm_ops);
@@ -0,0 +1,38 @@
+-- Сроки членства клиентов и их продление
+
+-- Виды членства с длительностью в месяцах; вид по умолчанию назначается новым клиентам
+CREATE TABLE IF NOT EXISTS membership_plans (
+    id              SERIAL PRIMARY KEY,
+    name            VARCHAR(100) NOT NULL UNIQUE,
+    duration_months INT NOT NULL CHECK (duration_months > 0),
+    is_default      BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS membership_plans_default_idx ON membership_plans (is_default) WHERE is_default;
+
+INSERT INTO membership_plans (name, duration_months, is_default)
+VALUES ('Годовое', 12, TRUE)
+ON CONFLICT (name) DO NOTHING;
+
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS membership_start DATE;
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS membership_expires DATE;
+
+-- Клиентам, записанным до введения сроков, дается год с момента миграции
+UPDATE clients
+SET membership_start = CURRENT_DATE, membership_expires = CURRENT_DATE + INTERVAL '1 year'
+WHERE membership_expires IS NULL;
+
+CREATE INDEX IF NOT EXISTS clients_membership_expires_idx ON clients (membership_expires);
+
+-- История продлений
+CREATE TABLE IF NOT EXISTS membership_renewals (
+    id               SERIAL PRIMARY KEY,
+    client_id        INT NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    plan_id          INT NOT NULL REFERENCES membership_plans(id),
+    previous_expires DATE,
+    new_expires      DATE NOT NULL,
+    renewed_by       VARCHAR(100),
+    renewed_at       TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS membership_renewals_client_idx ON membership_renewals (client_id);
@@ -0,0 +1,38 @@
+-- Категории читателей с собственными ограничениями выдачи
+
+CREATE TABLE IF NOT EXISTS patron_categories (
+    id                     SERIAL PRIMARY KEY,
+    name                   VARCHAR(100) NOT NULL UNIQUE,
+    -- Сколько книг всего может быть на руках
+    max_loans              INT NOT NULL CHECK (max_loans >= 0),
+    -- Множитель к сроку выдачи типа книги (book_types.day_count)
+    loan_period_multiplier NUMERIC(4, 2) NOT NULL DEFAULT 1 CHECK (loan_period_multiplier > 0),
+    -- Множитель к штрафу за день просрочки
+    fine_multiplier        NUMERIC(4, 2) NOT NULL DEFAULT 1 CHECK (fine_multiplier >= 0),
+    is_default             BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS patron_categories_default_idx ON patron_categories (is_default) WHERE is_default;
+
+-- Ограничения по типам книг; тип без строки ограничен только общим лимитом
+CREATE TABLE IF NOT EXISTS patron_category_type_limits (
+    category_id INT NOT NULL REFERENCES patron_categories(id) ON DELETE CASCADE,
+    type_id     INT NOT NULL REFERENCES book_types(id) ON DELETE CASCADE,
+    max_loans   INT NOT NULL CHECK (max_loans >= 0),
+    PRIMARY KEY (category_id, type_id)
+);
+
+INSERT INTO patron_categories (name, max_loans, loan_period_multiplier, fine_multiplier, is_default) VALUES
+    ('Ребенок', 5, 1, 0.5, FALSE),
+    ('Студент', 10, 1, 1, FALSE),
+    ('Взрослый', 10, 1, 1, TRUE),
+    ('Сотрудник', 20, 2, 0, FALSE)
+ON CONFLICT (name) DO NOTHING;
+
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS category_id INT REFERENCES patron_categories(id);
+
+-- Существующие клиенты получают категорию по умолчанию (прежний лимит в 10 книг)
+UPDATE clients SET category_id = (SELECT id FROM patron_categories WHERE is_default)
+WHERE category_id IS NULL;
+
+ALTER TABLE clients ALTER COLUMN category_id SET NOT NULL;
@@ -0,0 +1,30 @@
+-- Правила допуска к выдаче и ручные блокировки клиентов
+
+-- Настройки хранятся одной строкой
+CREATE TABLE IF NOT EXISTS eligibility_rules (
+    id                   INT PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    -- Максимальная сумма неоплаченных штрафов; NULL - без ограничения
+    max_outstanding_fine INT CHECK (max_outstanding_fine >= 0),
+    -- Блокировать при просроченных книгах на руках
+    block_on_overdue     BOOLEAN NOT NULL DEFAULT TRUE,
+    -- Сколько дней просрочки допускается до блокировки
+    overdue_grace_days   INT NOT NULL DEFAULT 0 CHECK (overdue_grace_days >= 0)
+);
+
+INSERT INTO eligibility_rules (id, max_outstanding_fine, block_on_overdue, overdue_grace_days)
+VALUES (1, 500, TRUE, 0)
+ON CONFLICT (id) DO NOTHING;
+
+-- Ручные блокировки; без expires_at действуют до снятия
+CREATE TABLE IF NOT EXISTS client_blocks (
+    id         SERIAL PRIMARY KEY,
+    client_id  INT NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    reason     TEXT NOT NULL,
+    expires_at TIMESTAMP,
+    created_by VARCHAR(100),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    lifted_at  TIMESTAMP,
+    lifted_by  VARCHAR(100)
+);
+
+CREATE INDEX IF NOT EXISTS client_blocks_client_idx ON client_blocks (client_id) WHERE lifted_at IS NULL;
@@ -0,0 +1,5 @@
+-- Обезличивание клиентов по запросу субъекта персональных данных.
+-- Строка клиента и журнал сохраняются для сводных отчетов, персональные данные стираются.
+
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS anonymized_at TIMESTAMP;
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS anonymized_by VARCHAR(100);
@@ -0,0 +1,12 @@
+-- Журнал слияний дублей клиентов
+
+CREATE TABLE IF NOT EXISTS client_merges (
+    id           SERIAL PRIMARY KEY,
+    survivor_id  INT NOT NULL REFERENCES clients(id),
+    merged_ids   INT[] NOT NULL,
+    merged_names TEXT[] NOT NULL,
+    moved_loans  INT NOT NULL,
+    reason       TEXT,
+    merged_by    VARCHAR(255),
+    merged_at    TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,28 @@
+-- Доступ читателей к собственным данным: вход по номеру билета и PIN, резервирование
+
+CREATE TABLE IF NOT EXISTS patron_credentials (
+    client_id       INT PRIMARY KEY REFERENCES clients(id) ON DELETE CASCADE,
+    pin_hash        TEXT NOT NULL,
+    -- Неудачные попытки входа подряд; после лимита вход блокируется на время
+    failed_attempts INT NOT NULL DEFAULT 0,
+    locked_until    TIMESTAMP,
+    updated_at      TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Показывать ли читателю историю выдач; по умолчанию история скрыта
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS loan_history_opt_in BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Резервирование издания (не конкретного экземпляра)
+CREATE TABLE IF NOT EXISTS holds (
+    id         SERIAL PRIMARY KEY,
+    client_id  INT NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    book_id    INT NOT NULL REFERENCES books(id),
+    -- waiting, ready, fulfilled, cancelled, expired
+    status     VARCHAR(10) NOT NULL DEFAULT 'waiting',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    closed_at  TIMESTAMP
+);
+
+-- Один действующий резерв клиента на издание
+CREATE UNIQUE INDEX IF NOT EXISTS holds_active_uniq ON holds (client_id, book_id) WHERE status IN ('waiting', 'ready');
+CREATE INDEX IF NOT EXISTS holds_queue_idx ON holds (book_id, created_at) WHERE status IN ('waiting', 'ready');
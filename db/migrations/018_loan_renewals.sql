@@ -0,0 +1,19 @@
+-- Продление выдач: правила продления по типу книги и история продлений
+
+-- Сколько раз можно продлить выдачу и на сколько дней просрочки продление еще допускается
+ALTER TABLE book_types ADD COLUMN IF NOT EXISTS max_renewals INT NOT NULL DEFAULT 2;
+ALTER TABLE book_types ADD COLUMN IF NOT EXISTS renewal_overdue_days INT NOT NULL DEFAULT 0;
+
+ALTER TABLE journal ADD COLUMN IF NOT EXISTS renewal_count INT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS loan_renewals (
+    id           SERIAL PRIMARY KEY,
+    journal_id   INT NOT NULL REFERENCES journal(id) ON DELETE CASCADE,
+    old_date_end DATE NOT NULL,
+    new_date_end DATE NOT NULL,
+    -- Логин библиотекаря; NULL - продлил сам читатель
+    renewed_by   VARCHAR(50),
+    renewed_at   TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS loan_renewals_journal_idx ON loan_renewals (journal_id);
@@ -0,0 +1,9 @@
+-- Очередь резервов: экземпляр, вернувшийся в библиотеку, откладывается для первого в очереди
+
+-- Когда экземпляр отложен и до какого дня читатель должен его забрать
+ALTER TABLE holds ADD COLUMN IF NOT EXISTS ready_at TIMESTAMP;
+ALTER TABLE holds ADD COLUMN IF NOT EXISTS pickup_deadline DATE;
+-- Выдача, которой исполнен резерв
+ALTER TABLE holds ADD COLUMN IF NOT EXISTS journal_id INT REFERENCES journal(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS holds_ready_idx ON holds (pickup_deadline) WHERE status = 'ready';
@@ -0,0 +1,68 @@
+-- Календарь библиотеки: часы работы по дням недели, праздники и разовые закрытия.
+-- Сроки возврата переносятся на ближайший рабочий день, штраф начисляется только за рабочие дни.
+
+-- День недели по ISO: 1 - понедельник, 7 - воскресенье; opens IS NULL - выходной
+CREATE TABLE IF NOT EXISTS opening_hours (
+    weekday SMALLINT PRIMARY KEY CHECK (weekday BETWEEN 1 AND 7),
+    opens   TIME,
+    closes  TIME,
+    CHECK ((opens IS NULL AND closes IS NULL) OR opens < closes)
+);
+
+INSERT INTO opening_hours (weekday, opens, closes) VALUES
+    (1, '09:00', '20:00'),
+    (2, '09:00', '20:00'),
+    (3, '09:00', '20:00'),
+    (4, '09:00', '20:00'),
+    (5, '09:00', '20:00'),
+    (6, '10:00', '18:00'),
+    (7, NULL, NULL)
+ON CONFLICT (weekday) DO NOTHING;
+
+-- Дни, когда библиотека закрыта. recurring - повторяется ежегодно в те же числа.
+CREATE TABLE IF NOT EXISTS calendar_closures (
+    id         SERIAL PRIMARY KEY,
+    date_from  DATE NOT NULL,
+    date_to    DATE NOT NULL,
+    -- holiday или closure
+    kind       VARCHAR(10) NOT NULL DEFAULT 'closure',
+    reason     TEXT NOT NULL DEFAULT '',
+    recurring  BOOLEAN NOT NULL DEFAULT FALSE,
+    -- UID события iCalendar, по нему повторный импорт обновляет запись
+    uid        TEXT UNIQUE,
+    created_by VARCHAR(100),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (date_to >= date_from)
+);
+
+CREATE INDEX IF NOT EXISTS calendar_closures_dates_idx ON calendar_closures (date_from, date_to);
+
+CREATE OR REPLACE FUNCTION library_is_open(d DATE) RETURNS BOOLEAN AS $$
+    SELECT EXISTS (SELECT 1 FROM opening_hours WHERE weekday = EXTRACT(ISODOW FROM d) AND opens IS NOT NULL)
+       AND NOT EXISTS (
+            SELECT 1 FROM calendar_closures c
+            WHERE (NOT c.recurring AND d BETWEEN c.date_from AND c.date_to)
+               OR (c.recurring AND CASE
+                    WHEN to_char(c.date_from, 'MMDD') <= to_char(c.date_to, 'MMDD')
+                    THEN to_char(d, 'MMDD') BETWEEN to_char(c.date_from, 'MMDD') AND to_char(c.date_to, 'MMDD')
+                    -- Ежегодное закрытие через Новый год
+                    ELSE to_char(d, 'MMDD') >= to_char(c.date_from, 'MMDD') OR to_char(d, 'MMDD') <= to_char(c.date_to, 'MMDD')
+                END)
+       )
+$$ LANGUAGE sql STABLE;
+
+-- Ближайший рабочий день начиная с d; если в течение года рабочих дней нет, возвращается d
+CREATE OR REPLACE FUNCTION library_next_open_day(d DATE) RETURNS DATE AS $$
+    SELECT COALESCE((
+        SELECT day::date FROM generate_series(d::timestamp, (d + 366)::timestamp, INTERVAL '1 day') AS day
+        WHERE library_is_open(day::date)
+        ORDER BY day
+        LIMIT 1
+    ), d)
+$$ LANGUAGE sql STABLE;
+
+-- Число рабочих дней в промежутке (d1, d2]
+CREATE OR REPLACE FUNCTION library_open_days(d1 DATE, d2 DATE) RETURNS INT AS $$
+    SELECT COUNT(*)::int FROM generate_series((d1 + 1)::timestamp, d2::timestamp, INTERVAL '1 day') AS day
+    WHERE library_is_open(day::date)
+$$ LANGUAGE sql STABLE;
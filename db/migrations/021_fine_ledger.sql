@@ -0,0 +1,47 @@
+-- Учет штрафов: начисления и поступления по клиенту; баланс - сумма проводок
+
+-- Квитанция об оплате; номер квитанции - id
+CREATE TABLE IF NOT EXISTS fine_receipts (
+    id          SERIAL PRIMARY KEY,
+    client_id   INT NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    amount      INT NOT NULL CHECK (amount > 0),
+    -- cash или card
+    method      VARCHAR(10) NOT NULL,
+    balance_before INT NOT NULL,
+    balance_after  INT NOT NULL,
+    received_by VARCHAR(100),
+    created_at  TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS fine_ledger (
+    id          SERIAL PRIMARY KEY,
+    client_id   INT NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    journal_id  INT REFERENCES journal(id) ON DELETE SET NULL,
+    -- overdue, lost_item - начисления; payment, partial_payment, waiver - поступления
+    entry_type  VARCHAR(16) NOT NULL,
+    -- Начисления положительные, поступления отрицательные
+    amount      INT NOT NULL CHECK (amount <> 0),
+    reason      TEXT NOT NULL DEFAULT '',
+    -- Кто утвердил списание
+    approved_by VARCHAR(100),
+    receipt_id  INT REFERENCES fine_receipts(id),
+    created_by  VARCHAR(100),
+    created_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK ((entry_type IN ('overdue', 'lost_item') AND amount > 0)
+        OR (entry_type IN ('payment', 'partial_payment', 'waiver') AND amount < 0)),
+    CHECK (entry_type <> 'waiver' OR (approved_by IS NOT NULL AND reason <> ''))
+);
+
+CREATE INDEX IF NOT EXISTS fine_ledger_client_idx ON fine_ledger (client_id, created_at);
+-- Одно начисление за просрочку на выдачу
+CREATE UNIQUE INDEX IF NOT EXISTS fine_ledger_overdue_uniq ON fine_ledger (journal_id) WHERE entry_type = 'overdue';
+
+-- Утерянная книга: выдача закрывается без возврата экземпляра
+ALTER TABLE journal ADD COLUMN IF NOT EXISTS lost BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Штрафы, начисленные до появления учета, переносятся начислениями
+INSERT INTO fine_ledger (client_id, journal_id, entry_type, amount, reason, created_at)
+SELECT j.client_id, j.id, 'overdue', j.fine_today, 'Перенесено из журнала', COALESCE(j.date_ret, NOW())
+FROM journal j
+WHERE j.fine_today > 0
+ON CONFLICT (journal_id) WHERE entry_type = 'overdue' DO NOTHING;
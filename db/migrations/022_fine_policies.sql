@@ -0,0 +1,39 @@
+-- Правила начисления штрафов и точные денежные суммы (NUMERIC вместо целых и float)
+
+ALTER TABLE book_types ALTER COLUMN fine TYPE NUMERIC(10, 2) USING fine::numeric;
+ALTER TABLE journal ALTER COLUMN fine_today TYPE NUMERIC(12, 2) USING fine_today::numeric;
+ALTER TABLE fine_ledger ALTER COLUMN amount TYPE NUMERIC(12, 2);
+ALTER TABLE fine_receipts ALTER COLUMN amount TYPE NUMERIC(12, 2);
+ALTER TABLE fine_receipts ALTER COLUMN balance_before TYPE NUMERIC(12, 2);
+ALTER TABLE fine_receipts ALTER COLUMN balance_after TYPE NUMERIC(12, 2);
+ALTER TABLE eligibility_rules ALTER COLUMN max_outstanding_fine TYPE NUMERIC(12, 2);
+
+CREATE TABLE IF NOT EXISTS fine_policies (
+    id                      SERIAL PRIMARY KEY,
+    name                    VARCHAR(100) NOT NULL UNIQUE,
+    -- Просрочка в пределах льготного периода не штрафуется
+    grace_days              INT NOT NULL DEFAULT 0 CHECK (grace_days >= 0),
+    -- Пределы штрафа; NULL - без предела
+    max_per_item            NUMERIC(12, 2) CHECK (max_per_item >= 0),
+    max_per_loan            NUMERIC(12, 2) CHECK (max_per_loan >= 0),
+    cap_at_replacement_cost BOOLEAN NOT NULL DEFAULT FALSE,
+    is_default              BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS fine_policies_default_idx ON fine_policies (is_default) WHERE is_default;
+
+-- Возрастающие ставки: с дня from_day просрочки действует ставка rate.
+-- Правила без ставок используют ставку типа книги.
+CREATE TABLE IF NOT EXISTS fine_policy_rates (
+    policy_id INT NOT NULL REFERENCES fine_policies(id) ON DELETE CASCADE,
+    from_day  INT NOT NULL CHECK (from_day >= 1),
+    rate      NUMERIC(10, 2) NOT NULL CHECK (rate >= 0),
+    PRIMARY KEY (policy_id, from_day)
+);
+
+-- Прежний расчет: ставка типа книги за каждый день без пределов
+INSERT INTO fine_policies (name, is_default) VALUES ('Стандартные', TRUE)
+ON CONFLICT (name) DO NOTHING;
+
+-- NULL - правила по умолчанию
+ALTER TABLE book_types ADD COLUMN IF NOT EXISTS fine_policy_id INT REFERENCES fine_policies(id);
@@ -0,0 +1,6 @@
+-- Ежедневное начисление штрафа по невозвращенным просроченным книгам в journal.fine_today
+
+-- День последнего пересчета; выдачи, пересчитанные сегодня, повторно не обрабатываются
+ALTER TABLE journal ADD COLUMN IF NOT EXISTS fine_accrued_on DATE;
+
+CREATE INDEX IF NOT EXISTS journal_open_date_end_idx ON journal (date_end) WHERE date_ret IS NULL;
@@ -0,0 +1,7 @@
+-- Ключ сравнения шифров полок: числа дополняются нулями до 20 знаков, буквы приводятся
+-- к верхнему регистру, поэтому A2 < A10 < B1 и a10 = A10
+
+CREATE OR REPLACE FUNCTION shelf_sort_key(shelf TEXT) RETURNS TEXT AS $$
+    SELECT string_agg(CASE WHEN part[1] ~ '^[0-9]' THEN lpad(part[1], 20, '0') ELSE upper(part[1]) END, '' ORDER BY n)
+    FROM regexp_matches(trim(shelf), '([0-9]+|[^0-9]+)', 'g') WITH ORDINALITY AS t(part, n)
+$$ LANGUAGE sql IMMUTABLE STRICT;
@@ -0,0 +1,5 @@
+-- Продление просроченной выдачи начисляет штраф за уже просроченные дни, поэтому
+-- по выдаче может быть несколько начислений за просрочку: при продлениях и при возврате.
+-- Повторное начисление при возврате исключает блокировка строки журнала.
+
+DROP INDEX IF EXISTS fine_ledger_overdue_uniq;
@@ -0,0 +1,160 @@
+// Расчет штрафов за просрочку по настраиваемым правилам, без HTTP и базы данных
+package fines
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// Суммы округляются до копеек
+const moneyPlaces = 2
+
+// Ставка за день просрочки начиная с дня FromDay (нумерация с 1)
+type RateStep struct {
+	FromDay int             `json:"from_day"`
+	Rate    decimal.Decimal `json:"rate"`
+}
+
+// Правила начисления штрафа
+type Policy struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Просрочка не больше GraceDays дней не штрафуется; при большей просрочке
+	// штраф начисляется за все дни
+	GraceDays int `json:"grace_days"`
+	// Возрастающие ставки; пусто - ставка типа книги за каждый день
+	Rates []RateStep `json:"rates"`
+	// Предел штрафа за просрочку одного экземпляра
+	MaxPerItem decimal.NullDecimal `json:"max_per_item"`
+	// Предел всех начислений по выдаче, включая возмещение утери
+	MaxPerLoan decimal.NullDecimal `json:"max_per_loan"`
+	// Штраф за просрочку не превышает стоимости возмещения экземпляра
+	CapAtReplacementCost bool `json:"cap_at_replacement_cost"`
+	IsDefault            bool `json:"is_default"`
+}
+
+// Данные выдачи, от которых зависит штраф
+type Item struct {
+	// Дни просрочки (рабочие дни библиотеки)
+	DaysLate int
+	// Ставка за день по типу книги; используется, если у правил нет своих ставок
+	BaseRate decimal.Decimal
+	// Множитель категории читателя
+	Multiplier decimal.Decimal
+	// Стоимость возмещения экземпляра, если известна
+	ReplacementCost decimal.NullDecimal
+	// Уже начисленное по выдаче помимо штрафа за просрочку (возмещение утери)
+	OtherCharges decimal.Decimal
+	// Штраф за просрочку, уже начисленный по выдаче при продлении; пределы
+	// за экземпляр и по стоимости возмещения считаются вместе с ним
+	PriorOverdue decimal.Decimal
+}
+
+// Какой предел ограничил штраф
+const (
+	CapNone            = ""
+	CapPerItem         = "per_item"
+	CapPerLoan         = "per_loan"
+	CapReplacementCost = "replacement_cost"
+)
+
+type Assessment struct {
+	DaysLate int `json:"days_late"`
+	// Дни, за которые начислен штраф; 0 - просрочка в пределах льготного периода
+	ChargedDays int             `json:"charged_days"`
+	Amount      decimal.Decimal `json:"amount"`
+	CappedBy    string          `json:"capped_by,omitempty"`
+}
+
+// Проверка правил перед сохранением
+func (p Policy) Validate() error {
+	if p.GraceDays < 0 {
+		return errors.New("grace_days must not be negative")
+	}
+	seen := map[int]bool{}
+	for _, step := range p.Rates {
+		if step.FromDay < 1 {
+			return errors.New("rates: from_day must be at least 1")
+		}
+		if seen[step.FromDay] {
+			return fmt.Errorf("rates: duplicate from_day %d", step.FromDay)
+		}
+		seen[step.FromDay] = true
+		if step.Rate.IsNegative() {
+			return errors.New("rates: rate must not be negative")
+		}
+	}
+	if len(p.Rates) > 0 && !seen[1] {
+		return errors.New("rates: the first step must start from day 1")
+	}
+	for name, limit := range map[string]decimal.NullDecimal{"max_per_item": p.MaxPerItem, "max_per_loan": p.MaxPerLoan} {
+		if limit.Valid && limit.Decimal.IsNegative() {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// Ставки по возрастанию дня начала
+func (p Policy) sortedRates() []RateStep {
+	rates := append([]RateStep(nil), p.Rates...)
+	sort.Slice(rates, func(i, j int) bool { return rates[i].FromDay < rates[j].FromDay })
+	return rates
+}
+
+// Штраф за просрочку по выдаче
+func (p Policy) Assess(item Item) Assessment {
+	result := Assessment{DaysLate: item.DaysLate, Amount: decimal.Zero}
+	if item.DaysLate <= 0 || item.DaysLate <= p.GraceDays {
+		return result
+	}
+	result.ChargedDays = item.DaysLate
+
+	// Сумма по ставкам: каждый день по ставке последней ступени, начавшейся не позже этого дня
+	amount := decimal.Zero
+	rates := p.sortedRates()
+	if len(rates) == 0 {
+		amount = item.BaseRate.Mul(decimal.NewFromInt(int64(item.DaysLate)))
+	}
+	for i, step := range rates {
+		if step.FromDay > item.DaysLate {
+			break
+		}
+		lastDay := item.DaysLate
+		if i+1 < len(rates) && rates[i+1].FromDay-1 < lastDay {
+			lastDay = rates[i+1].FromDay - 1
+		}
+		days := lastDay - step.FromDay + 1
+		amount = amount.Add(step.Rate.Mul(decimal.NewFromInt(int64(days))))
+	}
+	amount = amount.Mul(item.Multiplier)
+
+	// Пределы: за экземпляр, стоимость возмещения, по выдаче в целом
+	if p.MaxPerItem.Valid {
+		room := decimal.Max(p.MaxPerItem.Decimal.Sub(item.PriorOverdue), decimal.Zero)
+		if amount.GreaterThan(room) {
+			amount = room
+			result.CappedBy = CapPerItem
+		}
+	}
+	if p.CapAtReplacementCost && item.ReplacementCost.Valid {
+		room := decimal.Max(item.ReplacementCost.Decimal.Sub(item.PriorOverdue), decimal.Zero)
+		if amount.GreaterThan(room) {
+			amount = room
+			result.CappedBy = CapReplacementCost
+		}
+	}
+	if p.MaxPerLoan.Valid {
+		room := decimal.Max(p.MaxPerLoan.Decimal.Sub(item.OtherCharges).Sub(item.PriorOverdue), decimal.Zero)
+		if amount.GreaterThan(room) {
+			amount = room
+			result.CappedBy = CapPerLoan
+		}
+	}
+
+	result.Amount = amount.Round(moneyPlaces)
+	return result
+}
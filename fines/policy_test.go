@@ -0,0 +1,255 @@
+package fines
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func dec(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+func limit(s string) decimal.NullDecimal {
+	return decimal.NewNullDecimal(dec(s))
+}
+
+func TestAssess(t *testing.T) {
+	escalating := []RateStep{{FromDay: 1, Rate: dec("0.25")}, {FromDay: 5, Rate: dec("1.50")}, {FromDay: 11, Rate: dec("3")}}
+
+	tests := []struct {
+		name        string
+		policy      Policy
+		item        Item
+		amount      string
+		chargedDays int
+		cappedBy    string
+	}{
+		{
+			name:   "not overdue",
+			policy: Policy{},
+			item:   Item{DaysLate: 0, BaseRate: dec("10"), Multiplier: dec("1")},
+			amount: "0",
+		},
+		{
+			name:   "negative days",
+			policy: Policy{},
+			item:   Item{DaysLate: -3, BaseRate: dec("10"), Multiplier: dec("1")},
+			amount: "0",
+		},
+		{
+			name:        "base rate per day",
+			policy:      Policy{},
+			item:        Item{DaysLate: 3, BaseRate: dec("10"), Multiplier: dec("1")},
+			amount:      "30",
+			chargedDays: 3,
+		},
+		{
+			name:        "category multiplier",
+			policy:      Policy{},
+			item:        Item{DaysLate: 3, BaseRate: dec("10"), Multiplier: dec("0.5")},
+			amount:      "15",
+			chargedDays: 3,
+		},
+		{
+			name:   "within grace period",
+			policy: Policy{GraceDays: 2},
+			item:   Item{DaysLate: 2, BaseRate: dec("10"), Multiplier: dec("1")},
+			amount: "0",
+		},
+		{
+			name:        "past grace period charges every day",
+			policy:      Policy{GraceDays: 2},
+			item:        Item{DaysLate: 3, BaseRate: dec("10"), Multiplier: dec("1")},
+			amount:      "30",
+			chargedDays: 3,
+		},
+		{
+			name:        "first escalation step only",
+			policy:      Policy{Rates: escalating},
+			item:        Item{DaysLate: 4, BaseRate: dec("100"), Multiplier: dec("1")},
+			amount:      "1",
+			chargedDays: 4,
+		},
+		{
+			name:        "first day of second step",
+			policy:      Policy{Rates: escalating},
+			item:        Item{DaysLate: 5, BaseRate: dec("100"), Multiplier: dec("1")},
+			amount:      "2.5",
+			chargedDays: 5,
+		},
+		{
+			name:        "all steps",
+			policy:      Policy{Rates: escalating},
+			item:        Item{DaysLate: 12, BaseRate: dec("100"), Multiplier: dec("1")},
+			amount:      "16",
+			chargedDays: 12,
+		},
+		{
+			name:        "unsorted steps",
+			policy:      Policy{Rates: []RateStep{escalating[2], escalating[0], escalating[1]}},
+			item:        Item{DaysLate: 12, BaseRate: dec("100"), Multiplier: dec("1")},
+			amount:      "16",
+			chargedDays: 12,
+		},
+		{
+			name:        "escalating with multiplier",
+			policy:      Policy{Rates: escalating},
+			item:        Item{DaysLate: 12, BaseRate: dec("100"), Multiplier: dec("1.5")},
+			amount:      "24",
+			chargedDays: 12,
+		},
+		{
+			name:        "rounded half up to kopecks",
+			policy:      Policy{},
+			item:        Item{DaysLate: 3, BaseRate: dec("0.335"), Multiplier: dec("1")},
+			amount:      "1.01",
+			chargedDays: 3,
+		},
+		{
+			name:        "fractional rate is not truncated",
+			policy:      Policy{},
+			item:        Item{DaysLate: 7, BaseRate: dec("0.1"), Multiplier: dec("1")},
+			amount:      "0.7",
+			chargedDays: 7,
+		},
+		{
+			name:        "exactly at per-item cap",
+			policy:      Policy{MaxPerItem: limit("30")},
+			item:        Item{DaysLate: 3, BaseRate: dec("10"), Multiplier: dec("1")},
+			amount:      "30",
+			chargedDays: 3,
+		},
+		{
+			name:        "above per-item cap",
+			policy:      Policy{MaxPerItem: limit("25")},
+			item:        Item{DaysLate: 3, BaseRate: dec("10"), Multiplier: dec("1")},
+			amount:      "25",
+			chargedDays: 3,
+			cappedBy:    CapPerItem,
+		},
+		{
+			name:        "per-item cap counts overdue charged at renewal",
+			policy:      Policy{MaxPerItem: limit("25")},
+			item:        Item{DaysLate: 3, BaseRate: dec("10"), Multiplier: dec("1"), PriorOverdue: dec("20")},
+			amount:      "5",
+			chargedDays: 3,
+			cappedBy:    CapPerItem,
+		},
+		{
+			name:        "replacement cost cap",
+			policy:      Policy{CapAtReplacementCost: true},
+			item:        Item{DaysLate: 10, BaseRate: dec("10"), Multiplier: dec("1"), ReplacementCost: limit("75.50")},
+			amount:      "75.5",
+			chargedDays: 10,
+			cappedBy:    CapReplacementCost,
+		},
+		{
+			name:        "replacement cost unknown",
+			policy:      Policy{CapAtReplacementCost: true},
+			item:        Item{DaysLate: 10, BaseRate: dec("10"), Multiplier: dec("1")},
+			amount:      "100",
+			chargedDays: 10,
+		},
+		{
+			name:        "replacement cost cap disabled",
+			policy:      Policy{},
+			item:        Item{DaysLate: 10, BaseRate: dec("10"), Multiplier: dec("1"), ReplacementCost: limit("50")},
+			amount:      "100",
+			chargedDays: 10,
+		},
+		{
+			name:        "replacement cost below per-item cap",
+			policy:      Policy{MaxPerItem: limit("80"), CapAtReplacementCost: true},
+			item:        Item{DaysLate: 10, BaseRate: dec("10"), Multiplier: dec("1"), ReplacementCost: limit("60")},
+			amount:      "60",
+			chargedDays: 10,
+			cappedBy:    CapReplacementCost,
+		},
+		{
+			name:        "per-loan cap includes other charges",
+			policy:      Policy{MaxPerLoan: limit("100")},
+			item:        Item{DaysLate: 10, BaseRate: dec("10"), Multiplier: dec("1"), OtherCharges: dec("70")},
+			amount:      "30",
+			chargedDays: 10,
+			cappedBy:    CapPerLoan,
+		},
+		{
+			name:        "per-loan cap already used up",
+			policy:      Policy{MaxPerLoan: limit("100")},
+			item:        Item{DaysLate: 10, BaseRate: dec("10"), Multiplier: dec("1"), OtherCharges: dec("150")},
+			amount:      "0",
+			chargedDays: 10,
+			cappedBy:    CapPerLoan,
+		},
+		{
+			name:        "per-loan cap not reached",
+			policy:      Policy{MaxPerLoan: limit("100")},
+			item:        Item{DaysLate: 5, BaseRate: dec("10"), Multiplier: dec("1"), OtherCharges: dec("20")},
+			amount:      "50",
+			chargedDays: 5,
+		},
+		{
+			name:        "per-loan cap counts overdue charged at renewal",
+			policy:      Policy{MaxPerLoan: limit("100")},
+			item:        Item{DaysLate: 5, BaseRate: dec("10"), Multiplier: dec("1"), OtherCharges: dec("20"), PriorOverdue: dec("60")},
+			amount:      "20",
+			chargedDays: 5,
+			cappedBy:    CapPerLoan,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.Assess(tt.item)
+			if !got.Amount.Equal(dec(tt.amount)) {
+				t.Errorf("amount = %s, want %s", got.Amount, tt.amount)
+			}
+			if got.DaysLate != tt.item.DaysLate {
+				t.Errorf("days_late = %d, want %d", got.DaysLate, tt.item.DaysLate)
+			}
+			if got.ChargedDays != tt.chargedDays {
+				t.Errorf("charged_days = %d, want %d", got.ChargedDays, tt.chargedDays)
+			}
+			if got.CappedBy != tt.cappedBy {
+				t.Errorf("capped_by = %q, want %q", got.CappedBy, tt.cappedBy)
+			}
+		})
+	}
+}
+
+func TestAssessDoesNotReorderRates(t *testing.T) {
+	policy := Policy{Rates: []RateStep{{FromDay: 5, Rate: dec("2")}, {FromDay: 1, Rate: dec("1")}}}
+	policy.Assess(Item{DaysLate: 6, Multiplier: dec("1")})
+	if policy.Rates[0].FromDay != 5 {
+		t.Errorf("Assess reordered policy rates: %+v", policy.Rates)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		wantErr bool
+	}{
+		{name: "empty policy", policy: Policy{}},
+		{name: "escalating rates", policy: Policy{Rates: []RateStep{{FromDay: 1, Rate: dec("1")}, {FromDay: 8, Rate: dec("2")}}}},
+		{name: "zero limits", policy: Policy{MaxPerItem: limit("0"), MaxPerLoan: limit("0")}},
+		{name: "negative grace days", policy: Policy{GraceDays: -1}, wantErr: true},
+		{name: "step before day 1", policy: Policy{Rates: []RateStep{{FromDay: 0, Rate: dec("1")}}}, wantErr: true},
+		{name: "no step from day 1", policy: Policy{Rates: []RateStep{{FromDay: 2, Rate: dec("1")}}}, wantErr: true},
+		{name: "duplicate step", policy: Policy{Rates: []RateStep{{FromDay: 1, Rate: dec("1")}, {FromDay: 1, Rate: dec("2")}}}, wantErr: true},
+		{name: "negative rate", policy: Policy{Rates: []RateStep{{FromDay: 1, Rate: dec("-1")}}}, wantErr: true},
+		{name: "negative per-item cap", policy: Policy{MaxPerItem: limit("-1")}, wantErr: true},
+		{name: "negative per-loan cap", policy: Policy{MaxPerLoan: limit("-0.01")}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,11 +2,14 @@ module library-backend
 
 go 1.23.2
 
-require github.com/rs/cors v1.11.1
-
 require (
-	github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
-	github.com/gorilla/mux v1.8.1 // indirect
-	github.com/lib/pq v1.10.9 // indirect
-	golang.org/x/crypto v0.29.0 // indirect
+	github.com/boombuler/barcode v1.1.0
+	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/gorilla/mux v1.8.1
+	github.com/jung-kurt/gofpdf v1.16.2
+	github.com/lib/pq v1.10.9
+	github.com/rs/cors v1.11.1
+	github.com/shopspring/decimal v1.4.0
+	golang.org/x/crypto v0.29.0
+	golang.org/x/image v0.25.0
 )
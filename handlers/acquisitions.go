@@ -0,0 +1,507 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"library-backend/db"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type Vendor struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Contact string `json:"contact"`
+}
+
+type Fund struct {
+	ID     int     `json:"id"`
+	Name   string  `json:"name"`
+	Budget float64 `json:"budget"`
+}
+
+type PurchaseOrderLine struct {
+	ID       int     `json:"id"`
+	BookID   *int    `json:"book_id"`
+	Title    string  `json:"title"`
+	ISBN     string  `json:"isbn"`
+	TypeID   int     `json:"type_id"`
+	Quantity int     `json:"quantity"`
+	Received int     `json:"received"`
+	Price    float64 `json:"price"`
+}
+
+type PurchaseOrder struct {
+	ID        int                 `json:"id"`
+	VendorID  int                 `json:"vendor_id"`
+	FundID    int                 `json:"fund_id"`
+	Status    string              `json:"status"`
+	CreatedAt string              `json:"created_at"`
+	Lines     []PurchaseOrderLine `json:"lines"`
+}
+
+func GetVendors(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.DB.Query("SELECT id, name, COALESCE(contact, '') FROM vendors ORDER BY name")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var vendors []Vendor
+	for rows.Next() {
+		var vendor Vendor
+		if err := rows.Scan(&vendor.ID, &vendor.Name, &vendor.Contact); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		vendors = append(vendors, vendor)
+	}
+
+	json.NewEncoder(w).Encode(vendors)
+}
+
+func AddVendor(w http.ResponseWriter, r *http.Request) {
+	var vendor Vendor
+	if err := json.NewDecoder(r.Body).Decode(&vendor); err != nil || vendor.Name == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	_, err := db.DB.Exec("INSERT INTO vendors (name, contact) VALUES ($1, NULLIF($2, ''))", vendor.Name, vendor.Contact)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte("Vendor added successfully"))
+}
+
+func UpdateVendor(w http.ResponseWriter, r *http.Request) {
+	vendorID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid vendor ID", http.StatusBadRequest)
+		return
+	}
+
+	var vendor Vendor
+	if err := json.NewDecoder(r.Body).Decode(&vendor); err != nil || vendor.Name == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.DB.Exec("UPDATE vendors SET name=$1, contact=NULLIF($2, '') WHERE id=$3", vendor.Name, vendor.Contact, vendorID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if rowsAffected == 0 {
+		http.Error(w, "Vendor not found", http.StatusNotFound)
+		return
+	}
+
+	w.Write([]byte("Vendor updated successfully"))
+}
+
+func GetFunds(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.DB.Query("SELECT id, name, budget FROM funds ORDER BY name")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var funds []Fund
+	for rows.Next() {
+		var fund Fund
+		if err := rows.Scan(&fund.ID, &fund.Name, &fund.Budget); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		funds = append(funds, fund)
+	}
+
+	json.NewEncoder(w).Encode(funds)
+}
+
+func AddFund(w http.ResponseWriter, r *http.Request) {
+	var fund Fund
+	if err := json.NewDecoder(r.Body).Decode(&fund); err != nil || fund.Name == "" || fund.Budget < 0 {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	_, err := db.DB.Exec("INSERT INTO funds (name, budget) VALUES ($1, $2)", fund.Name, fund.Budget)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte("Fund added successfully"))
+}
+
+func UpdateFund(w http.ResponseWriter, r *http.Request) {
+	fundID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid fund ID", http.StatusBadRequest)
+		return
+	}
+
+	var fund Fund
+	if err := json.NewDecoder(r.Body).Decode(&fund); err != nil || fund.Name == "" || fund.Budget < 0 {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.DB.Exec("UPDATE funds SET name=$1, budget=$2 WHERE id=$3", fund.Name, fund.Budget, fundID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if rowsAffected == 0 {
+		http.Error(w, "Fund not found", http.StatusNotFound)
+		return
+	}
+
+	w.Write([]byte("Fund updated successfully"))
+}
+
+func GetPurchaseOrders(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.DB.Query("SELECT id, vendor_id, fund_id, status, created_at FROM purchase_orders ORDER BY created_at DESC")
+	if err != nil {
+		http.Error(w, "Error fetching purchase orders", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var orders []PurchaseOrder
+	for rows.Next() {
+		var order PurchaseOrder
+		if err := rows.Scan(&order.ID, &order.VendorID, &order.FundID, &order.Status, &order.CreatedAt); err != nil {
+			http.Error(w, "Error scanning purchase orders", http.StatusInternalServerError)
+			return
+		}
+		orders = append(orders, order)
+	}
+
+	json.NewEncoder(w).Encode(orders)
+}
+
+func GetPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	order, err := loadPurchaseOrder(orderID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Purchase order not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error fetching purchase order", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(order)
+}
+
+func loadPurchaseOrder(orderID int) (PurchaseOrder, error) {
+	var order PurchaseOrder
+	query := "SELECT id, vendor_id, fund_id, status, created_at FROM purchase_orders WHERE id = $1"
+	err := db.DB.QueryRow(query, orderID).Scan(&order.ID, &order.VendorID, &order.FundID, &order.Status, &order.CreatedAt)
+	if err != nil {
+		return order, err
+	}
+
+	rows, err := db.DB.Query(`
+        SELECT id, book_id, title, COALESCE(isbn, ''), type_id, quantity, received, price
+        FROM purchase_order_lines
+        WHERE order_id = $1
+        ORDER BY id`, orderID)
+	if err != nil {
+		return order, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line PurchaseOrderLine
+		var bookID sql.NullInt64
+		err := rows.Scan(&line.ID, &bookID, &line.Title, &line.ISBN, &line.TypeID, &line.Quantity, &line.Received, &line.Price)
+		if err != nil {
+			return order, err
+		}
+		if bookID.Valid {
+			id := int(bookID.Int64)
+			line.BookID = &id
+		}
+		order.Lines = append(order.Lines, line)
+	}
+
+	return order, rows.Err()
+}
+
+func AddPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	var order PurchaseOrder
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if len(order.Lines) == 0 {
+		http.Error(w, "Purchase order must have at least one line", http.StatusBadRequest)
+		return
+	}
+	for _, line := range order.Lines {
+		if line.Quantity <= 0 || line.Price < 0 || (line.BookID == nil && line.Title == "") {
+			http.Error(w, "Each line needs a book_id or title, positive quantity and non-negative price", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("INSERT INTO purchase_orders (vendor_id, fund_id) VALUES ($1, $2) RETURNING id", order.VendorID, order.FundID).Scan(&order.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for i, line := range order.Lines {
+		// Для уже существующей книги название и тип берем из каталога
+		if line.BookID == nil {
+			// Тип новой книги понадобится при приеме поставки
+			var exists bool
+			if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM book_types WHERE id = $1)", line.TypeID).Scan(&exists); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !exists {
+				writeValidationErrors(w, []FieldError{{fmt.Sprintf("lines[%d].type_id", i), "book type does not exist"}})
+				return
+			}
+		} else {
+			err := tx.QueryRow("SELECT name, type_id, COALESCE(isbn, '') FROM books WHERE id = $1", *line.BookID).Scan(&line.Title, &line.TypeID, &line.ISBN)
+			if err == sql.ErrNoRows {
+				http.Error(w, "Book not found", http.StatusNotFound)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		_, err := tx.Exec(`
+            INSERT INTO purchase_order_lines (order_id, book_id, title, isbn, type_id, quantity, price)
+            VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7)`,
+			order.ID, line.BookID, line.Title, line.ISBN, line.TypeID, line.Quantity, line.Price)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	order, err = loadPurchaseOrder(order.ID)
+	if err != nil {
+		http.Error(w, "Error fetching purchase order", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(order)
+}
+
+type ReceiveRequest struct {
+	Lines []struct {
+		LineID   int `json:"line_id"`
+		Quantity int `json:"quantity"`
+	} `json:"lines"`
+}
+
+// Прием поставки. Может быть частичным: каждая позиция принимается в указанном количестве,
+// а книги в каталоге создаются или пополняются автоматически.
+func ReceivePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	var request ReceiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Lines) == 0 {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow("SELECT status FROM purchase_orders WHERE id = $1 FOR UPDATE", orderID).Scan(&status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Purchase order not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if status == "received" {
+		http.Error(w, "Purchase order is already fully received", http.StatusConflict)
+		return
+	}
+
+	for _, item := range request.Lines {
+		var line PurchaseOrderLine
+		var bookID sql.NullInt64
+		err := tx.QueryRow(`
+            SELECT id, book_id, title, COALESCE(isbn, ''), type_id, quantity, received
+            FROM purchase_order_lines
+            WHERE id = $1 AND order_id = $2
+            FOR UPDATE`, item.LineID, orderID).Scan(&line.ID, &bookID, &line.Title, &line.ISBN, &line.TypeID, &line.Quantity, &line.Received)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Order line not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if item.Quantity <= 0 || line.Received+item.Quantity > line.Quantity {
+			http.Error(w, "Received quantity exceeds ordered quantity", http.StatusBadRequest)
+			return
+		}
+
+		// Книги еще нет в каталоге: ищем по ISBN, иначе заводим новую запись
+		if !bookID.Valid && line.ISBN != "" {
+			err := tx.QueryRow("SELECT id FROM books WHERE isbn = $1 ORDER BY id LIMIT 1", line.ISBN).Scan(&bookID)
+			if err != nil && err != sql.ErrNoRows {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if bookID.Valid {
+			_, err = tx.Exec("UPDATE books SET cnt = cnt + $1 WHERE id = $2", item.Quantity, bookID.Int64)
+		} else {
+			err = tx.QueryRow("INSERT INTO books (name, cnt, type_id, isbn) VALUES ($1, $2, $3, NULLIF($4, '')) RETURNING id",
+				line.Title, item.Quantity, line.TypeID, line.ISBN).Scan(&bookID)
+		}
+		if err != nil {
+			log.Println("Ошибка пополнения фонда:", err)
+			http.Error(w, "Error updating book count", http.StatusInternalServerError)
+			return
+		}
+
+		_, err = tx.Exec("UPDATE purchase_order_lines SET received = received + $1, book_id = $2 WHERE id = $3", item.Quantity, bookID.Int64, line.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	_, err = tx.Exec(`
+        UPDATE purchase_orders SET status = CASE
+            WHEN NOT EXISTS (SELECT 1 FROM purchase_order_lines WHERE order_id = $1 AND received < quantity) THEN 'received'
+            ELSE 'partially_received'
+        END
+        WHERE id = $1`, orderID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	order, err := loadPurchaseOrder(orderID)
+	if err != nil {
+		http.Error(w, "Error fetching purchase order", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(order)
+}
+
+type FundSpend struct {
+	FundID    int     `json:"fund_id"`
+	FundName  string  `json:"fund_name"`
+	Budget    float64 `json:"budget"`
+	Spent     float64 `json:"spent"`
+	Committed float64 `json:"committed"`
+	Remaining float64 `json:"remaining"`
+}
+
+// Отчет о расходах по фондам: потрачено (принятые экземпляры)
+// и зарезервировано (заказанные, но еще не полученные)
+func GetSpendByFund(w http.ResponseWriter, r *http.Request) {
+	query := `
+        SELECT
+            f.id,
+            f.name,
+            f.budget,
+            COALESCE(SUM(l.received * l.price), 0) AS spent,
+            COALESCE(SUM((l.quantity - l.received) * l.price), 0) AS committed
+        FROM funds f
+        LEFT JOIN purchase_orders o ON o.fund_id = f.id
+        LEFT JOIN purchase_order_lines l ON l.order_id = o.id
+        GROUP BY f.id, f.name, f.budget
+        ORDER BY f.name;
+    `
+
+	rows, err := db.DB.Query(query)
+	if err != nil {
+		http.Error(w, "Error fetching spend by fund", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var funds []FundSpend
+	for rows.Next() {
+		var fund FundSpend
+		if err := rows.Scan(&fund.FundID, &fund.FundName, &fund.Budget, &fund.Spent, &fund.Committed); err != nil {
+			http.Error(w, "Error scanning spend by fund", http.StatusInternalServerError)
+			return
+		}
+		fund.Remaining = fund.Budget - fund.Spent - fund.Committed
+		funds = append(funds, fund)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(funds)
+}
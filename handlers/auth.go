@@ -47,9 +47,9 @@ func LoginLibrarian(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Получаем хэш пароля из базы данных
-	var storedHash string
-	err := db.DB.QueryRow("SELECT password_hash FROM librarians WHERE username = $1", req.Username).Scan(&storedHash)
+	// Получаем хэш пароля и роль из базы данных
+	var storedHash, role string
+	err := db.DB.QueryRow("SELECT password_hash, role FROM librarians WHERE username = $1", req.Username).Scan(&storedHash, &role)
 	if err != nil {
 		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 		return
@@ -62,7 +62,7 @@ func LoginLibrarian(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Генерируем JWT токен
-	token, err := utils.GenerateJWT(req.Username)
+	token, err := utils.GenerateJWT(req.Username, role)
 	if err != nil {
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
@@ -72,3 +72,39 @@ func LoginLibrarian(w http.ResponseWriter, r *http.Request) {
 		"token": token,
 	})
 }
+
+// Роль библиотекаря с полными правами
+const roleAdmin = "admin"
+
+// Имя и роль библиотекаря из контекста (если маршрут закрыт AuthMiddleware) или из заголовка Authorization
+func currentUser(r *http.Request) (string, string) {
+	if username, ok := r.Context().Value("username").(string); ok {
+		role, _ := r.Context().Value("role").(string)
+		return username, role
+	}
+	if token := r.Header.Get("Authorization"); token != "" {
+		if username, role, err := utils.ValidateJWTWithRole(token); err == nil {
+			return username, role
+		}
+	}
+	return "", ""
+}
+
+func currentUsername(r *http.Request) string {
+	username, _ := currentUser(r)
+	return username
+}
+
+// Проверка роли текущего пользователя. При отказе ответ уже записан и возвращается false.
+func requireRole(w http.ResponseWriter, r *http.Request, role string) bool {
+	username, userRole := currentUser(r)
+	if username == "" {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return false
+	}
+	if userRole != role {
+		http.Error(w, "Insufficient privileges", http.StatusForbidden)
+		return false
+	}
+	return true
+}
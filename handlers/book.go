@@ -15,10 +15,16 @@ type Book struct {
 	Name   string `json:"name"`
 	Count  int    `json:"cnt"`
 	TypeID int    `json:"type_id"`
+	ISBN   string `json:"isbn"`
+	Shelf  string `json:"shelf"`
+	// Режим выдачи конкретной книги; null - как у типа книги
+	Circulating *bool `json:"circulating"`
+	// Только для обновления: сбросить режим выдачи книги к режиму типа
+	ClearCirculating bool `json:"clear_circulating,omitempty"`
 }
 
 func GetBooks(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.DB.Query("SELECT id, name, cnt, type_id FROM books")
+	rows, err := db.DB.Query("SELECT id, name, cnt, type_id, COALESCE(isbn, ''), COALESCE(shelf, ''), circulating FROM books")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -28,7 +34,7 @@ func GetBooks(w http.ResponseWriter, r *http.Request) {
 	var books []Book
 	for rows.Next() {
 		var book Book
-		err := rows.Scan(&book.ID, &book.Name, &book.Count, &book.TypeID)
+		err := rows.Scan(&book.ID, &book.Name, &book.Count, &book.TypeID, &book.ISBN, &book.Shelf, &book.Circulating)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -66,8 +72,8 @@ func GetBookByID(w http.ResponseWriter, r *http.Request) {
 	bookID := vars["id"]
 
 	var book Book
-	query := "SELECT id, name, cnt, type_id FROM books WHERE id = $1"
-	err := db.DB.QueryRow(query, bookID).Scan(&book.ID, &book.Name, &book.Count, &book.TypeID)
+	query := "SELECT id, name, cnt, type_id, COALESCE(isbn, ''), COALESCE(shelf, ''), circulating FROM books WHERE id = $1"
+	err := db.DB.QueryRow(query, bookID).Scan(&book.ID, &book.Name, &book.Count, &book.TypeID, &book.ISBN, &book.Shelf, &book.Circulating)
 	if err == sql.ErrNoRows {
 		http.Error(w, "Book not found", http.StatusNotFound)
 		return
@@ -87,8 +93,8 @@ func AddBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := "INSERT INTO books (name, cnt, type_id) VALUES ($1, $2, $3)"
-	_, err = db.DB.Exec(query, book.Name, book.Count, book.TypeID)
+	query := "INSERT INTO books (name, cnt, type_id, isbn, shelf, circulating) VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6)"
+	_, err = db.DB.Exec(query, book.Name, book.Count, book.TypeID, book.ISBN, book.Shelf, book.Circulating)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -113,8 +119,11 @@ func UpdateBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := "UPDATE books SET name=$1, cnt=$2, type_id=$3 WHERE id=$4"
-	res, err := db.DB.Exec(query, book.Name, book.Count, book.TypeID, bookID)
+	// Пустые isbn и shelf и отсутствующий circulating означают, что поле не передано:
+	// сохраненное значение не затирается
+	query := `UPDATE books SET name=$1, cnt=$2, type_id=$3, isbn=COALESCE(NULLIF($4, ''), isbn), shelf=COALESCE(NULLIF($5, ''), shelf),
+              circulating=CASE WHEN $6 THEN NULL ELSE COALESCE($7, circulating) END WHERE id=$8`
+	res, err := db.DB.Exec(query, book.Name, book.Count, book.TypeID, book.ISBN, book.Shelf, book.ClearCirculating, book.Circulating, bookID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"encoding/json"
+	"library-backend/db"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// Группа предполагаемых дублей и причина, по которой они сгруппированы
+type DuplicateGroup struct {
+	Reason     string  `json:"reason"`
+	Similarity float64 `json:"similarity"`
+	Books      []Book  `json:"books"`
+}
+
+// Нормализация названия для сравнения: регистр, ё/е, пунктуация и лишние пробелы
+func normalizeTitle(s string) string {
+	s = strings.ReplaceAll(strings.ToLower(s), "ё", "е")
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(fields, " ")
+}
+
+// Нормализация ISBN: только цифры и контрольный символ X
+func normalizeISBN(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(s) {
+		if unicode.IsDigit(r) || r == 'X' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func trigrams(s string) map[string]bool {
+	runes := []rune("  " + s + " ")
+	set := make(map[string]bool, len(runes))
+	for i := 0; i+3 <= len(runes); i++ {
+		set[string(runes[i:i+3])] = true
+	}
+	return set
+}
+
+// Сходство строк по коэффициенту Жаккара на триграммах (как pg_trgm)
+func trigramSimilarity(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for t := range a {
+		if b[t] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+// Поиск дублей: совпадающий ISBN или близкие нормализованные названия (?threshold=0.8).
+// Названия сравниваются только внутри блоков с одинаковым первым словом,
+// чтобы не сравнивать каждую книгу с каждой.
+func GetBookDuplicates(w http.ResponseWriter, r *http.Request) {
+	threshold, err := strconv.ParseFloat(r.URL.Query().Get("threshold"), 64)
+	if err != nil || threshold <= 0 || threshold > 1 {
+		threshold = 0.8
+	}
+
+	rows, err := db.DB.Query("SELECT id, name, cnt, type_id, COALESCE(isbn, ''), COALESCE(shelf, ''), circulating FROM books ORDER BY id")
+	if err != nil {
+		http.Error(w, "Error fetching books", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var books []Book
+	for rows.Next() {
+		var book Book
+		if err := rows.Scan(&book.ID, &book.Name, &book.Count, &book.TypeID, &book.ISBN, &book.Shelf, &book.Circulating); err != nil {
+			http.Error(w, "Error scanning books", http.StatusInternalServerError)
+			return
+		}
+		books = append(books, book)
+	}
+
+	var groups []DuplicateGroup
+	grouped := map[int]bool{}
+
+	byISBN := map[string][]Book{}
+	for _, book := range books {
+		if isbn := normalizeISBN(book.ISBN); isbn != "" {
+			byISBN[isbn] = append(byISBN[isbn], book)
+		}
+	}
+	for _, group := range byISBN {
+		if len(group) < 2 {
+			continue
+		}
+		for _, book := range group {
+			grouped[book.ID] = true
+		}
+		groups = append(groups, DuplicateGroup{Reason: "isbn", Similarity: 1, Books: group})
+	}
+
+	blocks := map[string][]int{}
+	titles := make([]string, len(books))
+	grams := make([]map[string]bool, len(books))
+	for i, book := range books {
+		titles[i] = normalizeTitle(book.Name)
+		grams[i] = trigrams(titles[i])
+		first, _, _ := strings.Cut(titles[i], " ")
+		blocks[first] = append(blocks[first], i)
+	}
+
+	for _, block := range blocks {
+		for x, i := range block {
+			if grouped[books[i].ID] {
+				continue
+			}
+			group := DuplicateGroup{Reason: "title", Similarity: 1, Books: []Book{books[i]}}
+			for _, j := range block[x+1:] {
+				if grouped[books[j].ID] {
+					continue
+				}
+				similarity := trigramSimilarity(grams[i], grams[j])
+				if similarity >= threshold {
+					group.Books = append(group.Books, books[j])
+					group.Similarity = min(group.Similarity, similarity)
+					grouped[books[j].ID] = true
+				}
+			}
+			if len(group.Books) > 1 {
+				grouped[books[i].ID] = true
+				groups = append(groups, group)
+			}
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Books[0].ID < groups[j].Books[0].ID })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+type BookMergeRequest struct {
+	SurvivorID   int    `json:"survivor_id"`
+	DuplicateIDs []int  `json:"duplicate_ids"`
+	Reason       string `json:"reason"`
+}
+
+// Слияние дублей в одну запись. Остаток суммируется, история выдач и все ссылки
+// переносятся на сохраняемую запись, дубли удаляются, слияние записывается в book_merges.
+func MergeBooks(w http.ResponseWriter, r *http.Request) {
+	var request BookMergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.DuplicateIDs) == 0 {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	for _, id := range request.DuplicateIDs {
+		if id == request.SurvivorID {
+			http.Error(w, "Survivor cannot be in duplicate_ids", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Блокируем все участвующие записи в порядке ID, чтобы избежать взаимоблокировок
+	ids := append([]int{request.SurvivorID}, request.DuplicateIDs...)
+	rows, err := tx.Query("SELECT id, name, cnt, COALESCE(isbn, '') FROM books WHERE id = ANY($1) ORDER BY id FOR UPDATE", pq.Array(ids))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var survivorISBN, firstDuplicateISBN string
+	var mergedNames []string
+	addedCnt, found := 0, 0
+	for rows.Next() {
+		var id, cnt int
+		var name, isbn string
+		if err := rows.Scan(&id, &name, &cnt, &isbn); err != nil {
+			rows.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		found++
+		if id == request.SurvivorID {
+			survivorISBN = isbn
+			continue
+		}
+		addedCnt += cnt
+		mergedNames = append(mergedNames, name)
+		if firstDuplicateISBN == "" {
+			firstDuplicateISBN = isbn
+		}
+	}
+	rows.Close()
+	if found != len(uniqueInts(ids)) {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
+
+	dupIDs := pq.Array(request.DuplicateIDs)
+
+	res, err := tx.Exec("UPDATE journal SET book_id = $1 WHERE book_id = ANY($2)", request.SurvivorID, dupIDs)
+	if err != nil {
+		log.Println("Ошибка переноса истории выдач:", err)
+		http.Error(w, "Error moving journal entries", http.StatusInternalServerError)
+		return
+	}
+	movedLoans, _ := res.RowsAffected()
+
+	// Остальные ссылки на дубли. Для таблиц с составным ключом строки дублей
+	// сливаются со строкой сохраняемой записи, а затем удаляются.
+	statements := []string{
+		"UPDATE purchase_order_lines SET book_id = $1 WHERE book_id = ANY($2)",
+		"UPDATE in_house_uses SET book_id = $1 WHERE book_id = ANY($2)",
+		// У клиента остается один действующий резерв на объединенное издание - самый ранний
+		`UPDATE holds SET status = 'cancelled', closed_at = NOW()
+            WHERE book_id = ANY($2) AND status IN ('waiting', 'ready')
+              AND EXISTS (SELECT 1 FROM holds o
+                          WHERE o.client_id = holds.client_id AND o.status IN ('waiting', 'ready')
+                            AND (o.book_id = $1 OR (o.book_id = ANY($2) AND o.id < holds.id)))`,
+		"UPDATE holds SET book_id = $1 WHERE book_id = ANY($2)",
+		`INSERT INTO book_classes (book_id, class_id)
+            SELECT $1, class_id FROM book_classes WHERE book_id = ANY($2)
+            ON CONFLICT DO NOTHING`,
+		"DELETE FROM book_classes WHERE book_id = ANY($2)",
+		`INSERT INTO stocktake_counts (session_id, book_id, counted)
+            SELECT session_id, $1, SUM(counted) FROM stocktake_counts WHERE book_id = ANY($2) GROUP BY session_id
+            ON CONFLICT (session_id, book_id) DO UPDATE SET counted = stocktake_counts.counted + EXCLUDED.counted`,
+		"DELETE FROM stocktake_counts WHERE book_id = ANY($2)",
+		`INSERT INTO stocktake_discrepancies
+                (session_id, book_id, expected, counted, on_loan, missing, unexpected, found_on_loan, in_scope)
+            SELECT session_id, $1, SUM(expected), SUM(counted), SUM(on_loan), SUM(missing), SUM(unexpected), SUM(found_on_loan), BOOL_OR(in_scope)
+            FROM stocktake_discrepancies WHERE book_id = ANY($2) GROUP BY session_id
+            ON CONFLICT (session_id, book_id) DO UPDATE SET
+                expected = stocktake_discrepancies.expected + EXCLUDED.expected,
+                counted = stocktake_discrepancies.counted + EXCLUDED.counted,
+                on_loan = stocktake_discrepancies.on_loan + EXCLUDED.on_loan,
+                missing = stocktake_discrepancies.missing + EXCLUDED.missing,
+                unexpected = stocktake_discrepancies.unexpected + EXCLUDED.unexpected,
+                found_on_loan = stocktake_discrepancies.found_on_loan + EXCLUDED.found_on_loan`,
+		"DELETE FROM stocktake_discrepancies WHERE book_id = ANY($2)",
+		// Матрица рекомендаций для затронутых книг устаревает; ее восстановит полная перестройка
+		"DELETE FROM book_cooccurrence WHERE book_id = ANY($2) OR other_book_id = ANY($2) OR $1 IN (book_id, other_book_id)",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, request.SurvivorID, dupIDs); err != nil {
+			log.Println("Ошибка переноса ссылок на дубли:", err)
+			http.Error(w, "Error merging books", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	isbn := survivorISBN
+	if isbn == "" {
+		isbn = firstDuplicateISBN
+	}
+	_, err = tx.Exec("UPDATE books SET cnt = cnt + $1, isbn = NULLIF($2, '') WHERE id = $3", addedCnt, isbn, request.SurvivorID)
+	if err != nil {
+		http.Error(w, "Error updating book count", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM books WHERE id = ANY($1)", dupIDs); err != nil {
+		log.Println("Ошибка удаления дублей:", err)
+		http.Error(w, "Error deleting duplicates", http.StatusInternalServerError)
+		return
+	}
+
+	// Добавленные экземпляры могут сразу уйти очереди резервов
+	if _, err := trapHolds(tx, request.SurvivorID); err != nil {
+		log.Println("Ошибка обработки очереди резервов:", err)
+		http.Error(w, "Error merging books", http.StatusInternalServerError)
+		return
+	}
+
+	var mergeID int
+	err = tx.QueryRow(`
+        INSERT INTO book_merges (survivor_id, merged_ids, merged_names, added_cnt, moved_loans, reason, merged_by)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''))
+        RETURNING id`,
+		request.SurvivorID, dupIDs, pq.Array(mergedNames), addedCnt, movedLoans, request.Reason, currentUsername(r)).Scan(&mergeID)
+	if err != nil {
+		http.Error(w, "Error recording merge", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		MergeID    int   `json:"merge_id"`
+		SurvivorID int   `json:"survivor_id"`
+		AddedCnt   int   `json:"added_cnt"`
+		MovedLoans int64 `json:"moved_loans"`
+	}{
+		MergeID:    mergeID,
+		SurvivorID: request.SurvivorID,
+		AddedCnt:   addedCnt,
+		MovedLoans: movedLoans,
+	})
+}
+
+type BookMerge struct {
+	ID          int      `json:"id"`
+	SurvivorID  int      `json:"survivor_id"`
+	MergedIDs   []int64  `json:"merged_ids"`
+	MergedNames []string `json:"merged_names"`
+	AddedCnt    int      `json:"added_cnt"`
+	MovedLoans  int      `json:"moved_loans"`
+	Reason      string   `json:"reason"`
+	MergedBy    string   `json:"merged_by"`
+	MergedAt    string   `json:"merged_at"`
+}
+
+func GetBookMerges(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.DB.Query(`
+        SELECT id, survivor_id, merged_ids, merged_names, added_cnt, moved_loans,
+               COALESCE(reason, ''), COALESCE(merged_by, ''), merged_at
+        FROM book_merges
+        ORDER BY merged_at DESC`)
+	if err != nil {
+		http.Error(w, "Error fetching merges", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var merges []BookMerge
+	for rows.Next() {
+		var m BookMerge
+		err := rows.Scan(&m.ID, &m.SurvivorID, pq.Array(&m.MergedIDs), pq.Array(&m.MergedNames), &m.AddedCnt, &m.MovedLoans, &m.Reason, &m.MergedBy, &m.MergedAt)
+		if err != nil {
+			http.Error(w, "Error scanning merges", http.StatusInternalServerError)
+			return
+		}
+		merges = append(merges, m)
+	}
+
+	json.NewEncoder(w).Encode(merges)
+}
+
+func uniqueInts(values []int) map[int]bool {
+	set := make(map[int]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
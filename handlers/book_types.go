@@ -8,17 +8,28 @@ import (
 	"strconv"
 
 	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
 )
 
 type BookType struct {
-	ID      int     `json:"id"`
-	Type    string  `json:"type"`
-	Fine    float64 `json:"fine"`
-	MaxDays int     `json:"day_count"`
+	ID      int             `json:"id"`
+	Type    string          `json:"type"`
+	Fine    decimal.Decimal `json:"fine"`
+	MaxDays int             `json:"day_count"`
+	// Выдача на дом разрешена; false - только читальный зал
+	Circulating *bool `json:"circulating"`
+	// Сколько раз можно продлить выдачу
+	MaxRenewals *int `json:"max_renewals"`
+	// Продление допускается при просрочке не более этого числа дней
+	RenewalOverdueDays *int `json:"renewal_overdue_days"`
+	// Правила штрафов для типа; nil - правила по умолчанию
+	FinePolicyID *int `json:"fine_policy_id"`
+	// Только для обновления: вернуть типу правила штрафов по умолчанию
+	ClearFinePolicy bool `json:"clear_fine_policy,omitempty"`
 }
 
 func GetBookTypes(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.DB.Query("SELECT id, type, fine, day_count FROM book_types")
+	rows, err := db.DB.Query("SELECT id, type, fine, day_count, circulating, max_renewals, renewal_overdue_days, fine_policy_id FROM book_types")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -28,7 +39,7 @@ func GetBookTypes(w http.ResponseWriter, r *http.Request) {
 	var bookTypes []BookType
 	for rows.Next() {
 		var bookType BookType
-		err := rows.Scan(&bookType.ID, &bookType.Type, &bookType.Fine, &bookType.MaxDays)
+		err := rows.Scan(&bookType.ID, &bookType.Type, &bookType.Fine, &bookType.MaxDays, &bookType.Circulating, &bookType.MaxRenewals, &bookType.RenewalOverdueDays, &bookType.FinePolicyID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -47,8 +58,9 @@ func AddBookType(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := "INSERT INTO book_types (type, fine, day_count) VALUES ($1, $2, $3)"
-	_, err = db.DB.Exec(query, bookType.Type, bookType.Fine, bookType.MaxDays)
+	query := `INSERT INTO book_types (type, fine, day_count, circulating, max_renewals, renewal_overdue_days, fine_policy_id)
+              VALUES ($1, $2, $3, COALESCE($4, TRUE), COALESCE($5, 2), COALESCE($6, 0), $7)`
+	_, err = db.DB.Exec(query, bookType.Type, bookType.Fine, bookType.MaxDays, bookType.Circulating, bookType.MaxRenewals, bookType.RenewalOverdueDays, bookType.FinePolicyID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -73,8 +85,11 @@ func UpdateBookType(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := "UPDATE book_types SET type=$1, fine=$2, day_count=$3 WHERE id=$4"
-	res, err := db.DB.Exec(query, bookType.Type, bookType.Fine, bookType.MaxDays, bookTypeID)
+	query := `UPDATE book_types SET type=$1, fine=$2, day_count=$3, circulating=COALESCE($4, circulating),
+              max_renewals=COALESCE($5, max_renewals), renewal_overdue_days=COALESCE($6, renewal_overdue_days),
+              fine_policy_id=CASE WHEN $8 THEN NULL ELSE COALESCE($7, fine_policy_id) END WHERE id=$9`
+	res, err := db.DB.Exec(query, bookType.Type, bookType.Fine, bookType.MaxDays, bookType.Circulating, bookType.MaxRenewals, bookType.RenewalOverdueDays,
+		bookType.FinePolicyID, bookType.ClearFinePolicy, bookTypeID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -133,8 +148,8 @@ func GetBookType(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var bookType BookType
-	query := "SELECT id, type, fine, day_count FROM book_types WHERE id = $1"
-	err = db.DB.QueryRow(query, typeID).Scan(&bookType.ID, &bookType.Type, &bookType.Fine, &bookType.MaxDays)
+	query := "SELECT id, type, fine, day_count, circulating, max_renewals, renewal_overdue_days, fine_policy_id FROM book_types WHERE id = $1"
+	err = db.DB.QueryRow(query, typeID).Scan(&bookType.ID, &bookType.Type, &bookType.Fine, &bookType.MaxDays, &bookType.Circulating, &bookType.MaxRenewals, &bookType.RenewalOverdueDays, &bookType.FinePolicyID)
 	if err == sql.ErrNoRows {
 		http.Error(w, "Book type not found", http.StatusNotFound)
 		return
@@ -0,0 +1,531 @@
+package handlers
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"library-backend/db"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Часы работы в день недели (1 - понедельник, 7 - воскресенье); пустые часы - выходной
+type OpeningHours struct {
+	Weekday int    `json:"weekday"`
+	Opens   string `json:"opens"`
+	Closes  string `json:"closes"`
+}
+
+type CalendarClosure struct {
+	ID       int    `json:"id"`
+	DateFrom string `json:"date_from"`
+	DateTo   string `json:"date_to"`
+	// holiday - праздник, closure - разовое закрытие
+	Kind      string `json:"kind"`
+	Reason    string `json:"reason"`
+	Recurring bool   `json:"recurring"`
+	UID       string `json:"uid"`
+	CreatedBy string `json:"created_by"`
+	CreatedAt string `json:"created_at"`
+}
+
+type CalendarDay struct {
+	Date   string `json:"date"`
+	Open   bool   `json:"open"`
+	Opens  string `json:"opens"`
+	Closes string `json:"closes"`
+}
+
+// Сколько дней можно запросить у календаря за раз
+const maxCalendarDays = 366
+
+// Сроки открытых выдач, выпавшие на нерабочий день, переносятся на ближайший рабочий;
+// так же переносятся сроки получения отложенных экземпляров. Вызывается после любого
+// изменения календаря. Возвращает число перенесенных выдач.
+func rollOpenLoanDueDates(tx *sql.Tx) (int64, error) {
+	res, err := tx.Exec(`
+        UPDATE journal SET date_end = library_next_open_day(date_end::date)
+        WHERE date_ret IS NULL AND NOT library_is_open(date_end::date)`)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`
+        UPDATE holds SET pickup_deadline = library_next_open_day(pickup_deadline)
+        WHERE status = 'ready' AND NOT library_is_open(pickup_deadline)`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func GetOpeningHours(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.DB.Query(`
+        SELECT weekday, COALESCE(to_char(opens, 'HH24:MI'), ''), COALESCE(to_char(closes, 'HH24:MI'), '')
+        FROM opening_hours
+        ORDER BY weekday`)
+	if err != nil {
+		http.Error(w, "Error fetching opening hours", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	hours := []OpeningHours{}
+	for rows.Next() {
+		var day OpeningHours
+		if err := rows.Scan(&day.Weekday, &day.Opens, &day.Closes); err != nil {
+			http.Error(w, "Error fetching opening hours", http.StatusInternalServerError)
+			return
+		}
+		hours = append(hours, day)
+	}
+
+	json.NewEncoder(w).Encode(hours)
+}
+
+// Замена часов работы на переданные дни недели; не переданные дни не меняются
+func UpdateOpeningHours(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+
+	var hours []OpeningHours
+	if err := json.NewDecoder(r.Body).Decode(&hours); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var errs []FieldError
+	for i, day := range hours {
+		field := fmt.Sprintf("[%d]", i)
+		if day.Weekday < 1 || day.Weekday > 7 {
+			errs = append(errs, FieldError{Field: field + ".weekday", Message: "must be between 1 and 7"})
+		}
+		if day.Opens == "" && day.Closes == "" {
+			continue
+		}
+		opens, err1 := time.Parse("15:04", day.Opens)
+		closes, err2 := time.Parse("15:04", day.Closes)
+		if err1 != nil || err2 != nil {
+			errs = append(errs, FieldError{Field: field, Message: "opens and closes must be HH:MM or both empty"})
+		} else if !opens.Before(closes) {
+			errs = append(errs, FieldError{Field: field, Message: "opens must be before closes"})
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, day := range hours {
+		_, err := tx.Exec(`
+            INSERT INTO opening_hours (weekday, opens, closes)
+            VALUES ($1, NULLIF($2, '')::time, NULLIF($3, '')::time)
+            ON CONFLICT (weekday) DO UPDATE SET opens = EXCLUDED.opens, closes = EXCLUDED.closes`,
+			day.Weekday, day.Opens, day.Closes)
+		if err != nil {
+			log.Println("Ошибка сохранения часов работы:", err)
+			http.Error(w, "Error saving opening hours", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	rolled, err := rollOpenLoanDueDates(tx)
+	if err != nil {
+		log.Println("Ошибка переноса сроков возврата:", err)
+		http.Error(w, "Error updating due dates", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		RolledLoans int64 `json:"rolled_loans"`
+	}{RolledLoans: rolled})
+}
+
+const calendarClosureColumns = `id, date_from::text, date_to::text, kind, reason, recurring, COALESCE(uid, ''),
+        COALESCE(created_by, ''), created_at::text`
+
+func scanCalendarClosure(row rowScanner) (CalendarClosure, error) {
+	var c CalendarClosure
+	err := row.Scan(&c.ID, &c.DateFrom, &c.DateTo, &c.Kind, &c.Reason, &c.Recurring, &c.UID, &c.CreatedBy, &c.CreatedAt)
+	return c, err
+}
+
+// Закрытия библиотеки; с ?from= и ?to= - пересекающие период (ежегодные возвращаются всегда)
+func GetCalendarClosures(w http.ResponseWriter, r *http.Request) {
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	for _, value := range []string{from, to} {
+		if value == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			http.Error(w, "Invalid date format. Use YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rows, err := db.DB.Query(`
+        SELECT `+calendarClosureColumns+`
+        FROM calendar_closures
+        WHERE recurring
+           OR ((NULLIF($1, '') IS NULL OR date_to >= NULLIF($1, '')::date) AND (NULLIF($2, '') IS NULL OR date_from <= NULLIF($2, '')::date))
+        ORDER BY date_from, id`, from, to)
+	if err != nil {
+		http.Error(w, "Error fetching closures", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	closures := []CalendarClosure{}
+	for rows.Next() {
+		closure, err := scanCalendarClosure(rows)
+		if err != nil {
+			http.Error(w, "Error fetching closures", http.StatusInternalServerError)
+			return
+		}
+		closures = append(closures, closure)
+	}
+
+	json.NewEncoder(w).Encode(closures)
+}
+
+func validateCalendarClosure(c CalendarClosure) []FieldError {
+	var errs []FieldError
+	from, err := time.Parse("2006-01-02", c.DateFrom)
+	if err != nil {
+		errs = append(errs, FieldError{Field: "date_from", Message: "must be YYYY-MM-DD"})
+	}
+	to, err := time.Parse("2006-01-02", c.DateTo)
+	if err != nil {
+		errs = append(errs, FieldError{Field: "date_to", Message: "must be YYYY-MM-DD"})
+	} else if to.Before(from) {
+		errs = append(errs, FieldError{Field: "date_to", Message: "must not be before date_from"})
+	}
+	if c.Kind != "holiday" && c.Kind != "closure" {
+		errs = append(errs, FieldError{Field: "kind", Message: "must be holiday or closure"})
+	}
+	return errs
+}
+
+func AddCalendarClosure(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+
+	var closure CalendarClosure
+	if err := json.NewDecoder(r.Body).Decode(&closure); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if closure.Kind == "" {
+		closure.Kind = "closure"
+	}
+	if closure.DateTo == "" {
+		closure.DateTo = closure.DateFrom
+	}
+	if errs := validateCalendarClosure(closure); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	closure, err = scanCalendarClosure(tx.QueryRow(`
+        INSERT INTO calendar_closures (date_from, date_to, kind, reason, recurring, created_by)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+        RETURNING `+calendarClosureColumns,
+		closure.DateFrom, closure.DateTo, closure.Kind, closure.Reason, closure.Recurring, currentUsername(r)))
+	if err != nil {
+		log.Println("Ошибка добавления закрытия:", err)
+		http.Error(w, "Error adding closure", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := rollOpenLoanDueDates(tx); err != nil {
+		log.Println("Ошибка переноса сроков возврата:", err)
+		http.Error(w, "Error updating due dates", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(closure)
+}
+
+// Удаление закрытия. Уже перенесенные сроки возврата не сокращаются.
+func DeleteCalendarClosure(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+
+	closureID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid closure ID", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.DB.Exec("DELETE FROM calendar_closures WHERE id = $1", closureID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Closure not found", http.StatusNotFound)
+		return
+	}
+
+	w.Write([]byte("Closure deleted successfully"))
+}
+
+// Рабочие и нерабочие дни за период ?from=&to= (по умолчанию 30 дней от сегодня)
+func GetCalendarDays(w http.ResponseWriter, r *http.Request) {
+	from := time.Now()
+	if value := r.URL.Query().Get("from"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			http.Error(w, "Invalid date format. Use YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		from = parsed
+	}
+	to := from.AddDate(0, 0, 30)
+	if value := r.URL.Query().Get("to"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			http.Error(w, "Invalid date format. Use YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		to = parsed
+	}
+	if to.Before(from) || to.Sub(from).Hours()/24 > maxCalendarDays {
+		http.Error(w, fmt.Sprintf("Period must be from 0 to %d days", maxCalendarDays), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+        SELECT d.day::date::text, library_is_open(d.day::date),
+               COALESCE(to_char(h.opens, 'HH24:MI'), ''), COALESCE(to_char(h.closes, 'HH24:MI'), '')
+        FROM generate_series($1::date::timestamp, $2::date::timestamp, INTERVAL '1 day') AS d(day)
+        LEFT JOIN opening_hours h ON h.weekday = EXTRACT(ISODOW FROM d.day)
+        ORDER BY d.day`, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		http.Error(w, "Error fetching calendar", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	days := []CalendarDay{}
+	for rows.Next() {
+		var day CalendarDay
+		if err := rows.Scan(&day.Date, &day.Open, &day.Opens, &day.Closes); err != nil {
+			http.Error(w, "Error fetching calendar", http.StatusInternalServerError)
+			return
+		}
+		if !day.Open {
+			day.Opens, day.Closes = "", ""
+		}
+		days = append(days, day)
+	}
+
+	json.NewEncoder(w).Encode(days)
+}
+
+// Импорт закрытий из iCalendar (RFC 5545). Каждое событие VEVENT становится закрытием
+// на дни, которые оно занимает; FREQ=YEARLY - ежегодным. События с другими правилами
+// повторения и отмененные пропускаются. Повторный импорт обновляет события по UID.
+func ImportCalendar(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		kind = "holiday"
+	}
+	if kind != "holiday" && kind != "closure" {
+		http.Error(w, "kind must be holiday or closure", http.StatusBadRequest)
+		return
+	}
+
+	closures, skipped, err := parseICalendar(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid iCalendar: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, closure := range closures {
+		_, err := tx.Exec(`
+            INSERT INTO calendar_closures (date_from, date_to, kind, reason, recurring, uid, created_by)
+            VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''))
+            ON CONFLICT (uid) DO UPDATE SET
+                date_from = EXCLUDED.date_from, date_to = EXCLUDED.date_to, kind = EXCLUDED.kind,
+                reason = EXCLUDED.reason, recurring = EXCLUDED.recurring`,
+			closure.DateFrom, closure.DateTo, kind, closure.Reason, closure.Recurring, closure.UID, currentUsername(r))
+		if err != nil {
+			log.Println("Ошибка импорта календаря:", err)
+			http.Error(w, "Error importing calendar", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	rolled, err := rollOpenLoanDueDates(tx)
+	if err != nil {
+		log.Println("Ошибка переноса сроков возврата:", err)
+		http.Error(w, "Error updating due dates", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Imported    int   `json:"imported"`
+		Skipped     int   `json:"skipped"`
+		RolledLoans int64 `json:"rolled_loans"`
+	}{Imported: len(closures), Skipped: skipped, RolledLoans: rolled})
+}
+
+// Разбор событий iCalendar в закрытия. Возвращает закрытия и число пропущенных событий.
+func parseICalendar(r io.Reader) ([]CalendarClosure, int, error) {
+	// Развертывание перенесенных строк: продолжение начинается с пробела или табуляции
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(lines) == 0 || !strings.EqualFold(strings.TrimSpace(lines[0]), "BEGIN:VCALENDAR") {
+		return nil, 0, fmt.Errorf("missing BEGIN:VCALENDAR")
+	}
+
+	var closures []CalendarClosure
+	skipped := 0
+	var event map[string]string
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		// Параметры свойства (DTSTART;VALUE=DATE) значения не меняют
+		name, _, _ = strings.Cut(name, ";")
+		name = strings.ToUpper(name)
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			event = map[string]string{}
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			if event == nil {
+				return nil, 0, fmt.Errorf("END:VEVENT without BEGIN")
+			}
+			closure, ok, err := icalEventClosure(event)
+			if err != nil {
+				return nil, 0, err
+			}
+			if ok {
+				closures = append(closures, closure)
+			} else {
+				skipped++
+			}
+			event = nil
+		case event != nil:
+			event[name] = value
+		}
+	}
+	return closures, skipped, nil
+}
+
+func icalEventClosure(event map[string]string) (CalendarClosure, bool, error) {
+	closure := CalendarClosure{
+		UID:    event["UID"],
+		Reason: icalUnescape(event["SUMMARY"]),
+	}
+	if strings.EqualFold(event["STATUS"], "CANCELLED") {
+		return closure, false, nil
+	}
+	if rule := strings.ToUpper(event["RRULE"]); rule != "" {
+		if !strings.Contains(rule, "FREQ=YEARLY") || strings.Contains(rule, "COUNT=") || strings.Contains(rule, "UNTIL=") {
+			return closure, false, nil
+		}
+		closure.Recurring = true
+	}
+
+	start, err := icalDate(event["DTSTART"])
+	if err != nil {
+		return closure, false, fmt.Errorf("event %q: DTSTART: %v", closure.UID, err)
+	}
+	end := start
+	if value, ok := event["DTEND"]; ok {
+		if end, err = icalDate(value); err != nil {
+			return closure, false, fmt.Errorf("event %q: DTEND: %v", closure.UID, err)
+		}
+		// Конец события не включается: у события на весь день DTEND - следующий день,
+		// у события до полуночи - тоже
+		if end.After(start) && (len(value) == 8 || strings.Contains(value, "T000000")) {
+			end = end.AddDate(0, 0, -1)
+		}
+	}
+	if end.Before(start) {
+		end = start
+	}
+
+	closure.DateFrom = start.Format("2006-01-02")
+	closure.DateTo = end.Format("2006-01-02")
+	return closure, true, nil
+}
+
+// Дата из значения DATE или DATE-TIME; время и часовой пояс отбрасываются
+func icalDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	return time.Parse("20060102", value[:8])
+}
+
+func icalUnescape(value string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
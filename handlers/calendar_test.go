@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseICalendar(t *testing.T) {
+	tests := []struct {
+		name     string
+		ics      string
+		closures []CalendarClosure
+		skipped  int
+		err      string
+	}{
+		{
+			name: "all-day event",
+			ics: `BEGIN:VCALENDAR
+BEGIN:VEVENT
+UID:newyear
+DTSTART;VALUE=DATE:20270101
+DTEND;VALUE=DATE:20270109
+SUMMARY:Новогодние каникулы
+END:VEVENT
+END:VCALENDAR`,
+			closures: []CalendarClosure{{UID: "newyear", DateFrom: "2027-01-01", DateTo: "2027-01-08", Reason: "Новогодние каникулы"}},
+		},
+		{
+			name: "single day without DTEND",
+			ics: `BEGIN:VCALENDAR
+BEGIN:VEVENT
+UID:sanitary
+DTSTART;VALUE=DATE:20270330
+SUMMARY:Санитарный день
+END:VEVENT
+END:VCALENDAR`,
+			closures: []CalendarClosure{{UID: "sanitary", DateFrom: "2027-03-30", DateTo: "2027-03-30", Reason: "Санитарный день"}},
+		},
+		{
+			name: "event until midnight with CRLF and escapes",
+			ics: "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:audit\r\nDTSTART:20270512T090000Z\r\nDTEND:20270514T000000Z\r\n" +
+				"SUMMARY:Проверка\\, ремонт\\; уборка\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+			closures: []CalendarClosure{{UID: "audit", DateFrom: "2027-05-12", DateTo: "2027-05-13", Reason: "Проверка, ремонт; уборка"}},
+		},
+		{
+			name: "folded lines",
+			ics: `BEGIN:VCALENDAR
+BEGIN:VEVENT
+UID:vic
+ tory
+DTSTART;VALUE=DATE:20270509
+SUMMARY:День
+  Победы
+END:VEVENT
+END:VCALENDAR`,
+			closures: []CalendarClosure{{UID: "victory", DateFrom: "2027-05-09", DateTo: "2027-05-09", Reason: "День Победы"}},
+		},
+		{
+			name: "yearly recurrence",
+			ics: `BEGIN:VCALENDAR
+BEGIN:VEVENT
+UID:women
+DTSTART;VALUE=DATE:20270308
+RRULE:FREQ=YEARLY
+SUMMARY:8 Марта
+END:VEVENT
+END:VCALENDAR`,
+			closures: []CalendarClosure{{UID: "women", DateFrom: "2027-03-08", DateTo: "2027-03-08", Reason: "8 Марта", Recurring: true}},
+		},
+		{
+			name: "skipped events",
+			ics: `BEGIN:VCALENDAR
+BEGIN:VEVENT
+UID:cancelled
+DTSTART;VALUE=DATE:20270601
+STATUS:CANCELLED
+END:VEVENT
+BEGIN:VEVENT
+UID:weekly
+DTSTART;VALUE=DATE:20270602
+RRULE:FREQ=WEEKLY
+END:VEVENT
+BEGIN:VEVENT
+UID:limited
+DTSTART;VALUE=DATE:20270603
+RRULE:FREQ=YEARLY;COUNT=3
+END:VEVENT
+BEGIN:VEVENT
+UID:kept
+DTSTART;VALUE=DATE:20270612
+END:VEVENT
+END:VCALENDAR`,
+			closures: []CalendarClosure{{UID: "kept", DateFrom: "2027-06-12", DateTo: "2027-06-12"}},
+			skipped:  3,
+		},
+		{
+			name: "end before start",
+			ics: `BEGIN:VCALENDAR
+BEGIN:VEVENT
+UID:reversed
+DTSTART;VALUE=DATE:20270710
+DTEND;VALUE=DATE:20270705
+END:VEVENT
+END:VCALENDAR`,
+			closures: []CalendarClosure{{UID: "reversed", DateFrom: "2027-07-10", DateTo: "2027-07-10"}},
+		},
+		{
+			name: "not a calendar",
+			ics:  "BEGIN:VCARD\nEND:VCARD\n",
+			err:  "missing BEGIN:VCALENDAR",
+		},
+		{
+			name: "empty input",
+			ics:  "",
+			err:  "missing BEGIN:VCALENDAR",
+		},
+		{
+			name: "END:VEVENT without BEGIN",
+			ics:  "BEGIN:VCALENDAR\nEND:VEVENT\nEND:VCALENDAR\n",
+			err:  "END:VEVENT without BEGIN",
+		},
+		{
+			name: "invalid start date",
+			ics:  "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:bad\nDTSTART:2027\nEND:VEVENT\nEND:VCALENDAR\n",
+			err:  `event "bad": DTSTART`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			closures, skipped, err := parseICalendar(strings.NewReader(tt.ics))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(closures, tt.closures) {
+				t.Errorf("closures = %+v, want %+v", closures, tt.closures)
+			}
+			if skipped != tt.skipped {
+				t.Errorf("skipped = %d, want %d", skipped, tt.skipped)
+			}
+		})
+	}
+}
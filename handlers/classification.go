@@ -0,0 +1,506 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"library-backend/db"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Рубрика классификатора. Схема задает классификатор: UDC, BBK или DDC
+type Class struct {
+	ID       int    `json:"id"`
+	Scheme   string `json:"scheme"`
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	ParentID *int   `json:"parent_id"`
+}
+
+var classSchemes = map[string]bool{"UDC": true, "BBK": true, "DDC": true}
+
+const classColumns = "id, scheme, code, name, parent_id"
+
+// Предел глубины рекурсивных запросов по дереву рубрик: защита от зацикливания,
+// если циклическая иерархия все же попала в таблицу
+const classMaxDepth = 100
+
+func scanClasses(rows *sql.Rows) ([]Class, error) {
+	defer rows.Close()
+
+	var classes []Class
+	for rows.Next() {
+		var class Class
+		var parentID sql.NullInt64
+		if err := rows.Scan(&class.ID, &class.Scheme, &class.Code, &class.Name, &parentID); err != nil {
+			return nil, err
+		}
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			class.ParentID = &id
+		}
+		classes = append(classes, class)
+	}
+	return classes, rows.Err()
+}
+
+// Корневые рубрики (или все рубрики схемы, если передан ?all=true)
+func GetClasses(w http.ResponseWriter, r *http.Request) {
+	scheme := strings.ToUpper(r.URL.Query().Get("scheme"))
+	if !classSchemes[scheme] {
+		http.Error(w, "Unknown classification scheme", http.StatusBadRequest)
+		return
+	}
+
+	query := "SELECT " + classColumns + " FROM classes WHERE scheme = $1 AND parent_id IS NULL ORDER BY code"
+	if r.URL.Query().Get("all") == "true" {
+		query = "SELECT " + classColumns + " FROM classes WHERE scheme = $1 ORDER BY code"
+	}
+
+	rows, err := db.DB.Query(query, scheme)
+	if err != nil {
+		http.Error(w, "Error fetching classes", http.StatusInternalServerError)
+		return
+	}
+
+	classes, err := scanClasses(rows)
+	if err != nil {
+		http.Error(w, "Error scanning classes", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(classes)
+}
+
+func GetClass(w http.ResponseWriter, r *http.Request) {
+	classID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid class ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.DB.Query("SELECT "+classColumns+" FROM classes WHERE id = $1", classID)
+	if err != nil {
+		http.Error(w, "Error fetching class", http.StatusInternalServerError)
+		return
+	}
+
+	classes, err := scanClasses(rows)
+	if err != nil {
+		http.Error(w, "Error scanning class", http.StatusInternalServerError)
+		return
+	}
+	if len(classes) == 0 {
+		http.Error(w, "Class not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(classes[0])
+}
+
+func AddClass(w http.ResponseWriter, r *http.Request) {
+	var class Class
+	if err := json.NewDecoder(r.Body).Decode(&class); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	class.Scheme = strings.ToUpper(class.Scheme)
+	if !classSchemes[class.Scheme] || class.Code == "" || class.Name == "" {
+		http.Error(w, "scheme, code and name are required", http.StatusBadRequest)
+		return
+	}
+
+	// Родитель должен принадлежать той же схеме
+	if class.ParentID != nil {
+		var parentScheme string
+		err := db.DB.QueryRow("SELECT scheme FROM classes WHERE id = $1", *class.ParentID).Scan(&parentScheme)
+		if err == sql.ErrNoRows || (err == nil && parentScheme != class.Scheme) {
+			http.Error(w, "Invalid parent class", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	query := "INSERT INTO classes (scheme, code, name, parent_id) VALUES ($1, $2, $3, $4) RETURNING id"
+	err := db.DB.QueryRow(query, class.Scheme, class.Code, class.Name, class.ParentID).Scan(&class.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(class)
+}
+
+func GetClassChildren(w http.ResponseWriter, r *http.Request) {
+	classID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid class ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.DB.Query("SELECT "+classColumns+" FROM classes WHERE parent_id = $1 ORDER BY code", classID)
+	if err != nil {
+		http.Error(w, "Error fetching classes", http.StatusInternalServerError)
+		return
+	}
+
+	classes, err := scanClasses(rows)
+	if err != nil {
+		http.Error(w, "Error scanning classes", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(classes)
+}
+
+// Цепочка предков от корня к непосредственному родителю
+func GetClassAncestors(w http.ResponseWriter, r *http.Request) {
+	classID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid class ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+        WITH RECURSIVE ancestors AS (
+            SELECT c.id, c.scheme, c.code, c.name, c.parent_id, 0 AS depth
+            FROM classes c
+            WHERE c.id = (SELECT parent_id FROM classes WHERE id = $1)
+            UNION ALL
+            SELECT p.id, p.scheme, p.code, p.name, p.parent_id, a.depth + 1
+            FROM classes p
+            JOIN ancestors a ON p.id = a.parent_id
+            WHERE a.depth < ` + strconv.Itoa(classMaxDepth) + `
+        )
+        SELECT id, scheme, code, name, parent_id FROM ancestors ORDER BY depth DESC`
+	rows, err := db.DB.Query(query, classID)
+	if err != nil {
+		http.Error(w, "Error fetching ancestors", http.StatusInternalServerError)
+		return
+	}
+
+	classes, err := scanClasses(rows)
+	if err != nil {
+		http.Error(w, "Error scanning classes", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(classes)
+}
+
+// Рекурсивный запрос поддерева рубрики, включая саму рубрику
+var classSubtreeCTE = `
+        WITH RECURSIVE subtree AS (
+            SELECT id, 0 AS depth FROM classes WHERE id = $1
+            UNION ALL
+            SELECT c.id, s.depth + 1 FROM classes c JOIN subtree s ON c.parent_id = s.id
+            WHERE s.depth < ` + strconv.Itoa(classMaxDepth) + `
+        )`
+
+// Книги, отнесенные к рубрике или к любой из ее дочерних рубрик
+func GetClassBooks(w http.ResponseWriter, r *http.Request) {
+	classID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid class ID", http.StatusBadRequest)
+		return
+	}
+
+	query := classSubtreeCTE + `
+        SELECT DISTINCT b.id, b.name, b.cnt, b.type_id, COALESCE(b.isbn, ''), COALESCE(b.shelf, '')
+        FROM books b
+        JOIN book_classes bc ON bc.book_id = b.id
+        JOIN subtree s ON s.id = bc.class_id
+        ORDER BY b.name`
+	rows, err := db.DB.Query(query, classID)
+	if err != nil {
+		http.Error(w, "Error fetching books", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var books []Book
+	for rows.Next() {
+		var book Book
+		if err := rows.Scan(&book.ID, &book.Name, &book.Count, &book.TypeID, &book.ISBN, &book.Shelf); err != nil {
+			http.Error(w, "Error scanning books", http.StatusInternalServerError)
+			return
+		}
+		books = append(books, book)
+	}
+
+	json.NewEncoder(w).Encode(books)
+}
+
+func GetBookClasses(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+        SELECT c.id, c.scheme, c.code, c.name, c.parent_id
+        FROM classes c
+        JOIN book_classes bc ON bc.class_id = c.id
+        WHERE bc.book_id = $1
+        ORDER BY c.scheme, c.code`
+	rows, err := db.DB.Query(query, bookID)
+	if err != nil {
+		http.Error(w, "Error fetching book classes", http.StatusInternalServerError)
+		return
+	}
+
+	classes, err := scanClasses(rows)
+	if err != nil {
+		http.Error(w, "Error scanning classes", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(classes)
+}
+
+// Полная замена набора рубрик книги
+func SetBookClasses(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		ClassIDs []int `json:"class_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM books WHERE id = $1)", bookID).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM book_classes WHERE book_id = $1", bookID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, classID := range request.ClassIDs {
+		_, err := tx.Exec("INSERT INTO book_classes (book_id, class_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", bookID, classID)
+		if err != nil {
+			http.Error(w, "Invalid class ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Book classes updated successfully"))
+}
+
+// Импорт таблицы классификатора из CSV со столбцами code,name,parent_code.
+// Схема передается в ?scheme=, существующие рубрики обновляются по коду.
+func ImportClasses(w http.ResponseWriter, r *http.Request) {
+	scheme := strings.ToUpper(r.URL.Query().Get("scheme"))
+	if !classSchemes[scheme] {
+		http.Error(w, "Unknown classification scheme", http.StatusBadRequest)
+		return
+	}
+
+	reader := csv.NewReader(r.Body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	type classRow struct {
+		code, name, parentCode string
+	}
+	var records []classRow
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Invalid CSV: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "code") {
+			continue
+		}
+		if len(record) < 2 || strings.TrimSpace(record[0]) == "" || strings.TrimSpace(record[1]) == "" {
+			http.Error(w, "Invalid CSV row "+strconv.Itoa(line)+": code and name are required", http.StatusBadRequest)
+			return
+		}
+
+		row := classRow{code: strings.TrimSpace(record[0]), name: strings.TrimSpace(record[1])}
+		if len(record) > 2 {
+			row.parentCode = strings.TrimSpace(record[2])
+		}
+		records = append(records, row)
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Первый проход создает рубрики, второй расставляет родителей,
+	// поэтому порядок строк в файле не важен
+	upsert := `
+        INSERT INTO classes (scheme, code, name) VALUES ($1, $2, $3)
+        ON CONFLICT (scheme, code) DO UPDATE SET name = EXCLUDED.name`
+	for _, row := range records {
+		if _, err := tx.Exec(upsert, scheme, row.code, row.name); err != nil {
+			log.Println("Ошибка импорта рубрики:", err)
+			http.Error(w, "Error importing classes", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	setParent := `
+        UPDATE classes SET parent_id = (SELECT id FROM classes WHERE scheme = $1 AND code = $3)
+        WHERE scheme = $1 AND code = $2`
+	for _, row := range records {
+		if row.parentCode == "" {
+			continue
+		}
+		var parentExists bool
+		err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM classes WHERE scheme = $1 AND code = $2)", scheme, row.parentCode).Scan(&parentExists)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !parentExists {
+			http.Error(w, "Unknown parent code "+row.parentCode+" for "+row.code, http.StatusBadRequest)
+			return
+		}
+		if _, err := tx.Exec(setParent, scheme, row.code, row.parentCode); err != nil {
+			http.Error(w, "Error importing classes", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Рубрика не может оказаться своим предком: ищем цепочку родителей, вернувшуюся к началу.
+	// Цепочки длиннее classMaxDepth тоже отклоняются - их не обойдут запросы по дереву.
+	var cycleCode string
+	err = tx.QueryRow(`
+        WITH RECURSIVE chain AS (
+            SELECT id AS start_id, parent_id, 1 AS depth FROM classes WHERE scheme = $1 AND parent_id IS NOT NULL
+            UNION ALL
+            SELECT ch.start_id, c.parent_id, ch.depth + 1
+            FROM chain ch
+            JOIN classes c ON c.id = ch.parent_id
+            WHERE ch.parent_id <> ch.start_id AND c.parent_id IS NOT NULL AND ch.depth < $2
+        )
+        SELECT c.code FROM chain ch JOIN classes c ON c.id = ch.start_id
+        WHERE ch.parent_id = ch.start_id OR ch.depth >= $2
+        ORDER BY c.code
+        LIMIT 1`, scheme, classMaxDepth).Scan(&cycleCode)
+	if err == nil {
+		http.Error(w, "Class hierarchy contains a cycle or is too deep at "+cycleCode, http.StatusBadRequest)
+		return
+	} else if err != sql.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Imported int `json:"imported"`
+	}{Imported: len(records)})
+}
+
+type SubjectStat struct {
+	ClassID     int    `json:"class_id"`
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Titles      int    `json:"titles"`
+	Copies      int    `json:"copies"`
+	BorrowCount int    `json:"borrow_count"`
+}
+
+// Отчет по верхним рубрикам схемы: названия, экземпляры на полках и выдачи по поддереву
+func GetBooksBySubject(w http.ResponseWriter, r *http.Request) {
+	scheme := strings.ToUpper(r.URL.Query().Get("scheme"))
+	if !classSchemes[scheme] {
+		http.Error(w, "Unknown classification scheme", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+        WITH RECURSIVE tree AS (
+            SELECT id AS root_id, id, 0 AS depth FROM classes WHERE scheme = $1 AND parent_id IS NULL
+            UNION ALL
+            SELECT t.root_id, c.id, t.depth + 1 FROM classes c JOIN tree t ON c.parent_id = t.id
+            WHERE t.depth < ` + strconv.Itoa(classMaxDepth) + `
+        ),
+        root_books AS (
+            SELECT DISTINCT t.root_id, bc.book_id
+            FROM tree t
+            JOIN book_classes bc ON bc.class_id = t.id
+        )
+        SELECT
+            c.id,
+            c.code,
+            c.name,
+            COUNT(rb.book_id) AS titles,
+            COALESCE(SUM(b.cnt), 0) AS copies,
+            COALESCE(SUM((SELECT COUNT(*) FROM journal j WHERE j.book_id = rb.book_id)), 0) AS borrow_count
+        FROM classes c
+        LEFT JOIN root_books rb ON rb.root_id = c.id
+        LEFT JOIN books b ON b.id = rb.book_id
+        WHERE c.scheme = $1 AND c.parent_id IS NULL
+        GROUP BY c.id, c.code, c.name
+        ORDER BY c.code;
+    `
+
+	rows, err := db.DB.Query(query, scheme)
+	if err != nil {
+		http.Error(w, "Error fetching books by subject", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var stats []SubjectStat
+	for rows.Next() {
+		var stat SubjectStat
+		if err := rows.Scan(&stat.ClassID, &stat.Code, &stat.Name, &stat.Titles, &stat.Copies, &stat.BorrowCount); err != nil {
+			http.Error(w, "Error scanning books by subject", http.StatusInternalServerError)
+			return
+		}
+		stats = append(stats, stat)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
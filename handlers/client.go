@@ -8,6 +8,7 @@ import (
 	"strconv"
 
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 )
 
 type Client struct {
@@ -17,10 +18,29 @@ type Client struct {
 	FatherName     string `json:"father_name"`
 	PassportSeria  string `json:"passport_seria"`
 	PassportNumber string `json:"passport_number"`
+	DocumentType   string `json:"document_type"`
+	Email          string `json:"email"`
+	Phone          string `json:"phone"`
+	Address        string `json:"address"`
+	// Предпочитаемые каналы уведомлений: email, sms, post
+	NotificationChannels []string `json:"notification_channels"`
+	// Согласие на уведомления по типам; заполняется только при запросе клиента по ID
+	Notifications map[string]bool `json:"notifications,omitempty"`
+	// Вид членства при записи; по умолчанию - вид, отмеченный как основной
+	MembershipPlanID  int    `json:"membership_plan_id,omitempty"`
+	MembershipStart   string `json:"membership_start"`
+	MembershipExpires string `json:"membership_expires"`
+	// Категория читателя; 0 при записи - категория по умолчанию, при обновлении - без изменений
+	CategoryID int `json:"category_id"`
 }
 
 func GetClients(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.DB.Query("SELECT id, first_name, last_name, father_name, passport_seria, passport_number  FROM clients")
+	reveal, ok := revealRequested(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := db.DB.Query("SELECT " + clientColumns + " FROM clients")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -29,8 +49,7 @@ func GetClients(w http.ResponseWriter, r *http.Request) {
 
 	var clients []Client
 	for rows.Next() {
-		var client Client
-		err := rows.Scan(&client.ID, &client.FirstName, &client.LastName, &client.FatherName, &client.PassportSeria, &client.PassportNumber)
+		client, err := scanClient(rows, reveal)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -67,9 +86,13 @@ func GetClientByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	var client Client
-	query := "SELECT id, first_name, last_name, father_name, passport_seria, passport_number FROM clients WHERE id = $1"
-	err := db.DB.QueryRow(query, id).Scan(&client.ID, &client.FirstName, &client.LastName, &client.FatherName, &client.PassportSeria, &client.PassportNumber)
+	reveal, ok := revealRequested(w, r)
+	if !ok {
+		return
+	}
+
+	query := "SELECT " + clientColumns + " FROM clients WHERE id = $1"
+	client, err := scanClient(db.DB.QueryRow(query, id), reveal)
 	if err == sql.ErrNoRows {
 		http.Error(w, "Client not found", http.StatusNotFound)
 		return
@@ -78,6 +101,12 @@ func GetClientByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	client.Notifications, err = loadNotificationPrefs(db.DB, client.ID)
+	if err != nil {
+		http.Error(w, "Error fetching notification preferences", http.StatusInternalServerError)
+		return
+	}
+
 	json.NewEncoder(w).Encode(client)
 }
 
@@ -90,13 +119,70 @@ func AddClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Вставляем данные в базу
-	query := "INSERT INTO clients (first_name, last_name, father_name, passport_seria, passport_number) VALUES ($1, $2, $3, $4, $5)"
-	_, err = db.DB.Exec(query, client.FirstName, client.LastName, client.FatherName, client.PassportSeria, client.PassportNumber)
+	if errs := validateClient(&client); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if !checkDocumentUnique(w, client, 0) {
+		return
+	}
+
+	doc, err := encryptDocument(client)
+	if err != nil {
+		http.Error(w, "Error encrypting client document", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.DB.Begin()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
+
+	plan, err := membershipPlanByID(tx, client.MembershipPlanID)
+	if err == sql.ErrNoRows {
+		writeValidationErrors(w, []FieldError{{"membership_plan_id", "unknown membership plan"}})
+		return
+	} else if err != nil {
+		http.Error(w, "Error fetching membership plan", http.StatusInternalServerError)
+		return
+	}
+
+	// Вставляем данные в базу
+	query := `INSERT INTO clients (first_name, last_name, father_name, document_type,
+            passport_seria_enc, passport_number_enc, passport_dek, document_bidx, passport_number_bidx,
+            email, phone, address, notification_channels, search_name, search_latin,
+            membership_start, membership_expires, category_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''), NULLIF($11, ''), NULLIF($12, ''), $13, $14, $15,
+            CURRENT_DATE, CURRENT_DATE + make_interval(months => $16),
+            COALESCE(NULLIF($17, 0), (SELECT id FROM patron_categories WHERE is_default)))
+        RETURNING id`
+	searchName, searchLatin := clientSearchFields(client)
+	err = tx.QueryRow(query, client.FirstName, client.LastName, client.FatherName, client.DocumentType,
+		doc.SeriaEnc, doc.NumberEnc, doc.WrappedDEK, nullBytes(doc.DocumentBidx), nullBytes(doc.NumberBidx),
+		client.Email, client.Phone, client.Address, pq.Array(client.NotificationChannels), searchName, searchLatin,
+		plan.DurationMonths, client.CategoryID).Scan(&client.ID)
+	if isUniqueViolation(err) {
+		writeValidationErrors(w, []FieldError{{"passport_number", "document is already registered"}})
+		return
+	} else if isForeignKeyViolation(err) {
+		writeValidationErrors(w, []FieldError{{"category_id", "unknown patron category"}})
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := saveNotificationPrefs(tx, client.ID, client.Notifications); err != nil {
+		http.Error(w, "Error saving notification preferences", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte("Client added successfully"))
@@ -117,13 +203,52 @@ func UpdateClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Обновляем данные клиента
-	query := "UPDATE clients SET first_name=$1, last_name=$2, father_name=$3, passport_seria=$4, passport_number=$5 WHERE id=$6"
-	res, err := db.DB.Exec(query, client.FirstName, client.LastName, client.FatherName, client.PassportSeria, client.PassportNumber, clientID)
+	// Без новых реквизитов документ остается прежним и не перешифровывается
+	keepDocument := documentOmitted(client)
+	var errs []FieldError
+	if keepDocument {
+		errs = validateClientProfile(&client)
+	} else {
+		errs = validateClient(&client)
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	var doc encryptedDocument
+	if !keepDocument {
+		if !checkDocumentUnique(w, client, clientID) {
+			return
+		}
+		if doc, err = encryptDocument(client); err != nil {
+			http.Error(w, "Error encrypting client document", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	tx, err := db.DB.Begin()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
+
+	// Обновляем данные клиента
+	query := `UPDATE clients SET first_name=$1, last_name=$2, father_name=$3,
+            email=NULLIF($4, ''), phone=NULLIF($5, ''), address=NULLIF($6, ''), notification_channels=$7,
+            search_name=$8, search_latin=$9, category_id=COALESCE(NULLIF($10, 0), category_id)
+        WHERE id=$11 AND anonymized_at IS NULL`
+	searchName, searchLatin := clientSearchFields(client)
+	res, err := tx.Exec(query, client.FirstName, client.LastName, client.FatherName,
+		client.Email, client.Phone, client.Address, pq.Array(client.NotificationChannels), searchName, searchLatin, client.CategoryID, clientID)
+	if isForeignKeyViolation(err) {
+		writeValidationErrors(w, []FieldError{{"category_id", "unknown patron category"}})
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	rowsAffected, err := res.RowsAffected()
 	if err != nil {
@@ -136,6 +261,33 @@ func UpdateClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Новый документ прошел проверку уникальности, поэтому флаг дубля снимается
+	if !keepDocument {
+		_, err = tx.Exec(`
+            UPDATE clients SET document_type=$1, passport_seria_enc=$2, passport_number_enc=$3, passport_dek=$4,
+                document_bidx=$5, passport_number_bidx=$6, document_conflict=FALSE
+            WHERE id=$7`,
+			client.DocumentType, doc.SeriaEnc, doc.NumberEnc, doc.WrappedDEK, nullBytes(doc.DocumentBidx), nullBytes(doc.NumberBidx), clientID)
+		if isUniqueViolation(err) {
+			writeValidationErrors(w, []FieldError{{"passport_number", "document is already registered"}})
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Настройки уведомлений меняются только если переданы
+	if err := saveNotificationPrefs(tx, clientID, client.Notifications); err != nil {
+		http.Error(w, "Error saving notification preferences", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Write([]byte("Client updated successfully"))
 }
 
@@ -147,9 +299,35 @@ func DeleteClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Клиента с историей выдач удалять нельзя: журнал нужен для отчетов
+	var hasHistory bool
+	err = tx.QueryRow(`
+        SELECT EXISTS (SELECT 1 FROM journal WHERE client_id = $1)
+            OR EXISTS (SELECT 1 FROM in_house_uses WHERE client_id = $1)`, clientID).Scan(&hasHistory)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if hasHistory {
+		http.Error(w, "Client has circulation history, anonymize instead", http.StatusConflict)
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM library_cards WHERE client_id = $1", clientID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// Удаляем клиента
 	query := "DELETE FROM clients WHERE id=$1"
-	res, err := db.DB.Exec(query, clientID)
+	res, err := tx.Exec(query, clientID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -166,5 +344,33 @@ func DeleteClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Write([]byte("Client deleted successfully"))
 }
+
+// Проверка, что документ не зарегистрирован за другим клиентом.
+// При ошибке ответ уже записан и возвращается false.
+func checkDocumentUnique(w http.ResponseWriter, client Client, exceptID int) bool {
+	var existingID int
+	query := "SELECT id FROM clients WHERE document_bidx = $1 AND id <> $2"
+	bidx := documentBlindIndex(client.DocumentType, client.PassportSeria, client.PassportNumber)
+	err := db.DB.QueryRow(query, bidx, exceptID).Scan(&existingID)
+	if err == sql.ErrNoRows {
+		return true
+	} else if err != nil {
+		http.Error(w, "Error checking client document", http.StatusInternalServerError)
+		return false
+	}
+
+	writeValidationErrors(w, []FieldError{{"passport_number", "document is already registered for client " + strconv.Itoa(existingID)}})
+	return false
+}
+
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
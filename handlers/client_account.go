@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"library-backend/db"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+type Loan struct {
+	JournalID   int             `json:"journal_id"`
+	BookID      int             `json:"book_id"`
+	BookName    string          `json:"book_name"`
+	DateBeg     string          `json:"date_beg"`
+	DateEnd     string          `json:"date_end"`
+	DateRet     string          `json:"date_ret"`
+	Overdue     bool            `json:"overdue"`
+	DaysOverdue int             `json:"days_overdue"`
+	Fine        decimal.Decimal `json:"fine"`
+	// Штраф, набежавший на текущую дату по невозвращенной просроченной книге (по правилам штрафов)
+	AccruedFine decimal.Decimal `json:"accrued_fine"`
+}
+
+// Выдачи клиента с названиями книг: текущие (open) или вся история
+func loadClientLoans(q queryer, clientID int, open bool) ([]Loan, error) {
+	rows, err := q.Query(`
+        SELECT j.id, j.book_id, b.name, j.date_beg::text, j.date_end::text, COALESCE(j.date_ret::text, ''),
+               j.date_ret IS NULL AND j.date_end < CURRENT_DATE,
+               CASE WHEN j.date_ret IS NULL AND j.date_end < CURRENT_DATE THEN CURRENT_DATE - j.date_end::date ELSE 0 END,
+               COALESCE(j.fine_today, 0)
+        FROM journal j
+        JOIN books b ON j.book_id = b.id
+        WHERE j.client_id = $1 AND (NOT $2 OR j.date_ret IS NULL)
+        ORDER BY j.date_beg DESC`, clientID, open)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loans := []Loan{}
+	for rows.Next() {
+		var loan Loan
+		if err := rows.Scan(&loan.JournalID, &loan.BookID, &loan.BookName, &loan.DateBeg, &loan.DateEnd, &loan.DateRet,
+			&loan.Overdue, &loan.DaysOverdue, &loan.Fine); err != nil {
+			return nil, err
+		}
+		loans = append(loans, loan)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range loans {
+		if !loans[i].Overdue {
+			continue
+		}
+		policy, item, err := loanFineItem(q, loans[i].JournalID)
+		if err != nil {
+			return nil, err
+		}
+		loans[i].AccruedFine = policy.Assess(item).Amount
+	}
+	return loans, nil
+}
+
+type AccountFines struct {
+	// Остаток по учету штрафов: начислено и не оплачено и не списано
+	Unpaid decimal.Decimal `json:"unpaid"`
+	// Набегает по невозвращенным просроченным книгам
+	Accrued decimal.Decimal `json:"accrued"`
+	Total   decimal.Decimal `json:"total"`
+}
+
+// Состояние клиента целиком: профиль, выдачи, штрафы, резервы и блокировки
+type ClientAccount struct {
+	Profile     Client         `json:"profile"`
+	Category    PatronCategory `json:"category"`
+	Membership  Membership     `json:"membership"`
+	CardNumber  string         `json:"card_number"`
+	Loans       []Loan         `json:"loans"`
+	Overdue     int            `json:"overdue"`
+	Fines       AccountFines   `json:"fines"`
+	Holds       []Hold         `json:"holds"`
+	Blocks      []ClientBlock  `json:"blocks"`
+	Eligibility Eligibility    `json:"eligibility"`
+}
+
+// Аккаунт клиента одним запросом. Все части читаются в одной транзакции
+// REPEATABLE READ, поэтому согласованы между собой.
+func GetClientAccount(w http.ResponseWriter, r *http.Request) {
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	reveal, ok := revealRequested(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.BeginTx(r.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var account ClientAccount
+	account.Profile, err = scanClient(tx.QueryRow("SELECT "+clientColumns+" FROM clients WHERE id = $1", clientID), reveal)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error fetching client", http.StatusInternalServerError)
+		return
+	}
+
+	if err := loadAccount(tx, &account); err != nil {
+		log.Println("Ошибка получения аккаунта клиента:", err)
+		http.Error(w, "Error fetching client account", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(account)
+}
+
+func loadAccount(tx *sql.Tx, account *ClientAccount) error {
+	clientID := account.Profile.ID
+
+	var err error
+	if account.Profile.Notifications, err = loadNotificationPrefs(tx, clientID); err != nil {
+		return err
+	}
+	if account.Category, err = clientCategory(tx, clientID); err != nil {
+		return err
+	}
+	if account.Membership, err = loadMembership(tx, clientID); err != nil {
+		return err
+	}
+
+	err = tx.QueryRow("SELECT card_number FROM library_cards WHERE client_id = $1 AND status = 'active'", clientID).Scan(&account.CardNumber)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if account.Loans, err = loadClientLoans(tx, clientID, true); err != nil {
+		return err
+	}
+	for _, loan := range account.Loans {
+		if loan.Overdue {
+			account.Overdue++
+		}
+		account.Fines.Accrued = account.Fines.Accrued.Add(loan.AccruedFine)
+	}
+
+	if account.Fines.Unpaid, err = clientOutstandingFine(tx, clientID); err != nil {
+		return err
+	}
+	account.Fines.Total = account.Fines.Unpaid.Add(account.Fines.Accrued)
+
+	if account.Holds, err = loadClientHolds(tx, clientID, true); err != nil {
+		return err
+	}
+
+	// Только действующие блокировки: не снятые и не истекшие
+	rows, err := tx.Query(`
+        SELECT `+clientBlockColumns+`
+        FROM client_blocks
+        WHERE client_id = $1 AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+        ORDER BY created_at DESC`, clientID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	account.Blocks = []ClientBlock{}
+	for rows.Next() {
+		block, err := scanClientBlock(rows)
+		if err != nil {
+			return err
+		}
+		account.Blocks = append(account.Blocks, block)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	account.Eligibility, err = evaluateEligibility(tx, clientID)
+	return err
+}
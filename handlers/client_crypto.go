@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"library-backend/db"
+	"library-backend/utils"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Паспортные данные хранятся зашифрованными ключом записи (DEK), который сам
+// зашифрован KEK. Для уникальности и точного поиска используются слепые индексы.
+type encryptedDocument struct {
+	SeriaEnc     []byte
+	NumberEnc    []byte
+	WrappedDEK   []byte
+	DocumentBidx []byte
+	NumberBidx   []byte
+}
+
+const clientColumns = `id, first_name, last_name, father_name, document_type, passport_seria_enc, passport_number_enc, passport_dek,
+        COALESCE(email, ''), COALESCE(phone, ''), COALESCE(address, ''), notification_channels,
+        COALESCE(membership_start::text, ''), COALESCE(membership_expires::text, ''), category_id`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// Индекс документа целиком; по нему проверяется уникальность
+func documentBlindIndex(documentType, seria, number string) []byte {
+	return utils.BlindIndex("document", documentType, seria, number)
+}
+
+// Индекс номера документа для точного поиска клиента
+func passportNumberBlindIndex(number string) []byte {
+	return utils.BlindIndex("passport_number", normalizeDocumentField(number))
+}
+
+// Пустой индекс записывается как NULL: []byte(nil) драйвер передает пустой строкой
+func nullBytes(b []byte) any {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+func encryptDocument(client Client) (encryptedDocument, error) {
+	var doc encryptedDocument
+	dek, wrapped, err := utils.NewDataKey()
+	if err != nil {
+		return doc, err
+	}
+	doc.WrappedDEK = wrapped
+
+	if doc.SeriaEnc, err = utils.Seal(dek, []byte(client.PassportSeria)); err != nil {
+		return doc, err
+	}
+	if doc.NumberEnc, err = utils.Seal(dek, []byte(client.PassportNumber)); err != nil {
+		return doc, err
+	}
+
+	// Пустой документ (только у старых записей) не индексируется, иначе все такие
+	// клиенты считались бы дублями друг друга
+	if client.PassportNumber == "" {
+		return doc, nil
+	}
+	doc.DocumentBidx = documentBlindIndex(client.DocumentType, client.PassportSeria, client.PassportNumber)
+	doc.NumberBidx = passportNumberBlindIndex(client.PassportNumber)
+	return doc, nil
+}
+
+func decryptDocument(seriaEnc, numberEnc, wrappedDEK []byte) (string, string, error) {
+	dek, err := utils.UnwrapDataKey(wrappedDEK)
+	if err != nil {
+		return "", "", err
+	}
+	seria, err := utils.Open(dek, seriaEnc)
+	if err != nil {
+		return "", "", err
+	}
+	number, err := utils.Open(dek, numberEnc)
+	if err != nil {
+		return "", "", err
+	}
+	return string(seria), string(number), nil
+}
+
+// Маска оставляет видимыми только два последних символа
+func maskValue(s string) string {
+	runes := []rune(s)
+	if len(runes) <= 2 {
+		return strings.Repeat("*", len(runes))
+	}
+	return strings.Repeat("*", len(runes)-2) + string(runes[len(runes)-2:])
+}
+
+// Документ не передан или передан в маске из ответа GET: при обновлении клиента
+// сохраненные реквизиты не меняются. Настоящие реквизиты не содержат "*".
+func documentOmitted(client Client) bool {
+	if client.PassportSeria == "" && client.PassportNumber == "" {
+		return true
+	}
+	return strings.Contains(client.PassportSeria, "*") || strings.Contains(client.PassportNumber, "*")
+}
+
+// Чтение клиента из строки с колонками clientColumns.
+// Без reveal паспортные данные возвращаются замаскированными.
+func scanClient(row rowScanner, reveal bool) (Client, error) {
+	var client Client
+	var seriaEnc, numberEnc, wrappedDEK []byte
+	err := row.Scan(&client.ID, &client.FirstName, &client.LastName, &client.FatherName, &client.DocumentType, &seriaEnc, &numberEnc, &wrappedDEK,
+		&client.Email, &client.Phone, &client.Address, pq.Array(&client.NotificationChannels),
+		&client.MembershipStart, &client.MembershipExpires, &client.CategoryID)
+	if err != nil {
+		return client, err
+	}
+
+	if wrappedDEK == nil {
+		return client, nil
+	}
+	client.PassportSeria, client.PassportNumber, err = decryptDocument(seriaEnc, numberEnc, wrappedDEK)
+	if err != nil {
+		return client, err
+	}
+	if !reveal {
+		client.PassportSeria = maskValue(client.PassportSeria)
+		client.PassportNumber = maskValue(client.PassportNumber)
+	}
+	return client, nil
+}
+
+// Полные паспортные данные (?reveal=true) доступны только администратору.
+// При отказе ответ уже записан и ok = false.
+func revealRequested(w http.ResponseWriter, r *http.Request) (reveal bool, ok bool) {
+	if r.URL.Query().Get("reveal") != "true" {
+		return false, true
+	}
+	if !requireRole(w, r, roleAdmin) {
+		return false, false
+	}
+	return true, true
+}
+
+// Шифрование паспортных данных, сохраненных до появления шифрования.
+// Вызывается при старте; открытые значения после шифрования очищаются.
+func EncryptLegacyPassports() error {
+	rows, err := db.DB.Query(`
+        SELECT id, document_type, COALESCE(passport_seria, ''), COALESCE(passport_number, '')
+        FROM clients
+        WHERE passport_dek IS NULL AND anonymized_at IS NULL`)
+	if err != nil {
+		return err
+	}
+
+	var clients []Client
+	for rows.Next() {
+		var client Client
+		if err := rows.Scan(&client.ID, &client.DocumentType, &client.PassportSeria, &client.PassportNumber); err != nil {
+			rows.Close()
+			return err
+		}
+		clients = append(clients, client)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, client := range clients {
+		doc, err := encryptDocument(client)
+		if err != nil {
+			return err
+		}
+		_, err = db.DB.Exec(`
+            UPDATE clients SET
+                passport_seria_enc = $1, passport_number_enc = $2, passport_dek = $3,
+                document_bidx = $4, passport_number_bidx = $5,
+                passport_seria = NULL, passport_number = NULL
+            WHERE id = $6`,
+			doc.SeriaEnc, doc.NumberEnc, doc.WrappedDEK, nullBytes(doc.DocumentBidx), nullBytes(doc.NumberBidx), client.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(clients) > 0 {
+		log.Println("Зашифрованы паспортные данные клиентов:", len(clients))
+	}
+	return nil
+}
@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"library-backend/db"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+type ClientDuplicateGroup struct {
+	Reason     string   `json:"reason"`
+	Similarity float64  `json:"similarity"`
+	Clients    []Client `json:"clients"`
+}
+
+// Объединение пар в группы (система непересекающихся множеств)
+type unionFind map[int]int
+
+func (u unionFind) find(x int) int {
+	if _, ok := u[x]; !ok {
+		u[x] = x
+	}
+	for u[x] != x {
+		u[x] = u[u[x]]
+		x = u[x]
+	}
+	return x
+}
+
+func (u unionFind) union(a, b int) {
+	u[u.find(a)] = u.find(b)
+}
+
+// Поиск дублей клиентов: одинаковый номер документа или похожее ФИО (?threshold=0.7).
+// Похожие ФИО ищутся в базе через pg_trgm по полю search_name.
+func GetClientDuplicates(w http.ResponseWriter, r *http.Request) {
+	threshold, err := strconv.ParseFloat(r.URL.Query().Get("threshold"), 64)
+	if err != nil || threshold <= 0 || threshold > 1 {
+		threshold = 0.7
+	}
+
+	type pair struct {
+		a, b       int
+		similarity float64
+	}
+	var passportPairs, namePairs []pair
+
+	rows, err := db.DB.Query(`
+        SELECT a.id, b.id
+        FROM clients a
+        JOIN clients b ON b.passport_number_bidx = a.passport_number_bidx AND b.id > a.id
+        WHERE a.anonymized_at IS NULL AND b.anonymized_at IS NULL`)
+	if err != nil {
+		http.Error(w, "Error fetching clients", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		p := pair{similarity: 1}
+		if err := rows.Scan(&p.a, &p.b); err != nil {
+			rows.Close()
+			http.Error(w, "Error scanning clients", http.StatusInternalServerError)
+			return
+		}
+		passportPairs = append(passportPairs, p)
+	}
+	rows.Close()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Порог оператора % задается на время транзакции
+	if _, err := tx.Exec("SELECT set_config('pg_trgm.similarity_threshold', $1, true)", strconv.FormatFloat(threshold, 'f', 2, 64)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rows, err = tx.Query(`
+        SELECT a.id, b.id, similarity(a.search_name, b.search_name)
+        FROM clients a
+        JOIN clients b ON b.search_name % a.search_name AND b.id > a.id
+        WHERE a.anonymized_at IS NULL AND b.anonymized_at IS NULL AND a.search_name <> ''`)
+	if err != nil {
+		log.Println("Ошибка поиска похожих клиентов:", err)
+		http.Error(w, "Error fetching clients", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var p pair
+		if err := rows.Scan(&p.a, &p.b, &p.similarity); err != nil {
+			rows.Close()
+			http.Error(w, "Error scanning clients", http.StatusInternalServerError)
+			return
+		}
+		namePairs = append(namePairs, p)
+	}
+	rows.Close()
+
+	// Совпадение документа сильнее сходства имен: такие клиенты группируются первыми
+	members := map[int][]int{}
+	uf := unionFind{}
+	reasons := map[int]string{}
+	for _, p := range passportPairs {
+		uf.union(p.a, p.b)
+	}
+	for _, p := range passportPairs {
+		reasons[uf.find(p.a)] = "passport"
+	}
+	for _, p := range namePairs {
+		if reasons[uf.find(p.a)] == "passport" || reasons[uf.find(p.b)] == "passport" {
+			continue
+		}
+		uf.union(p.a, p.b)
+	}
+	similarity := map[int]float64{}
+	for _, p := range namePairs {
+		root := uf.find(p.a)
+		if reasons[root] == "passport" {
+			continue
+		}
+		reasons[root] = "name"
+		if s, ok := similarity[root]; !ok || p.similarity < s {
+			similarity[root] = p.similarity
+		}
+	}
+
+	var ids []int
+	for id := range uf {
+		root := uf.find(id)
+		members[root] = append(members[root], id)
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		json.NewEncoder(w).Encode([]ClientDuplicateGroup{})
+		return
+	}
+
+	clients := map[int]Client{}
+	rows, err = tx.Query("SELECT "+clientColumns+" FROM clients WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		http.Error(w, "Error fetching clients", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		client, err := scanClient(rows, false)
+		if err != nil {
+			rows.Close()
+			http.Error(w, "Error scanning clients", http.StatusInternalServerError)
+			return
+		}
+		clients[client.ID] = client
+	}
+	rows.Close()
+
+	var groups []ClientDuplicateGroup
+	for root, ids := range members {
+		sort.Ints(ids)
+		group := ClientDuplicateGroup{Reason: reasons[root], Similarity: 1}
+		if group.Reason == "name" {
+			group.Similarity = similarity[root]
+		}
+		for _, id := range ids {
+			group.Clients = append(group.Clients, clients[id])
+		}
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Clients[0].ID < groups[j].Clients[0].ID })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+type ClientMergeRequest struct {
+	SurvivorID   int    `json:"survivor_id"`
+	DuplicateIDs []int  `json:"duplicate_ids"`
+	Reason       string `json:"reason"`
+}
+
+// Слияние дублей клиента. Журнал выдач (со штрафами), посещения читального зала,
+// блокировки, резервы, история членства и билеты переносятся на сохраняемую запись,
+// дубли удаляются, слияние записывается в client_merges. Слияние необратимо,
+// поэтому доступно только администратору.
+func MergeClients(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+
+	var request ClientMergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.DuplicateIDs) == 0 {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	for _, id := range request.DuplicateIDs {
+		if id == request.SurvivorID {
+			http.Error(w, "Survivor cannot be in duplicate_ids", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Блокируем все участвующие записи в порядке ID, чтобы избежать взаимоблокировок
+	ids := append([]int{request.SurvivorID}, request.DuplicateIDs...)
+	rows, err := tx.Query(`
+        SELECT id, last_name || ' ' || first_name || COALESCE(' ' || NULLIF(father_name, ''), ''), anonymized_at IS NOT NULL
+        FROM clients WHERE id = ANY($1) ORDER BY id FOR UPDATE`, pq.Array(ids))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var mergedNames []string
+	found, anonymized := 0, false
+	for rows.Next() {
+		var id int
+		var name string
+		var isAnonymized bool
+		if err := rows.Scan(&id, &name, &isAnonymized); err != nil {
+			rows.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		found++
+		anonymized = anonymized || isAnonymized
+		if id != request.SurvivorID {
+			mergedNames = append(mergedNames, name)
+		}
+	}
+	rows.Close()
+	if found != len(uniqueInts(ids)) {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	if anonymized {
+		http.Error(w, "Anonymized clients cannot be merged", http.StatusConflict)
+		return
+	}
+
+	// Книги всех записей окажутся у одного клиента - проверяем лимиты его категории
+	category, err := clientCategory(tx, request.SurvivorID)
+	if err != nil {
+		http.Error(w, "Error checking client category", http.StatusInternalServerError)
+		return
+	}
+	rows, err = tx.Query(`
+        SELECT b.type_id, COUNT(*)
+        FROM journal j
+        JOIN books b ON j.book_id = b.id
+        WHERE j.client_id = ANY($1) AND j.date_ret IS NULL
+        GROUP BY b.type_id`, pq.Array(ids))
+	if err != nil {
+		http.Error(w, "Error checking client's books", http.StatusInternalServerError)
+		return
+	}
+	onHand := 0
+	for rows.Next() {
+		var typeID, count int
+		if err := rows.Scan(&typeID, &count); err != nil {
+			rows.Close()
+			http.Error(w, "Error checking client's books", http.StatusInternalServerError)
+			return
+		}
+		onHand += count
+		if limit, ok := category.typeLimit(typeID); ok && count > limit {
+			rows.Close()
+			http.Error(w, fmt.Sprintf("Combined loans of book type %d (%d) exceed the limit of %d", typeID, count, limit), http.StatusConflict)
+			return
+		}
+	}
+	rows.Close()
+	if onHand > category.MaxLoans {
+		http.Error(w, fmt.Sprintf("Combined loans (%d) exceed the limit of %d", onHand, category.MaxLoans), http.StatusConflict)
+		return
+	}
+
+	dupIDs := pq.Array(request.DuplicateIDs)
+
+	res, err := tx.Exec("UPDATE journal SET client_id = $1 WHERE client_id = ANY($2)", request.SurvivorID, dupIDs)
+	if err != nil {
+		log.Println("Ошибка переноса истории выдач:", err)
+		http.Error(w, "Error moving journal entries", http.StatusInternalServerError)
+		return
+	}
+	movedLoans, _ := res.RowsAffected()
+
+	statements := []string{
+		"UPDATE in_house_uses SET client_id = $1 WHERE client_id = ANY($2)",
+		"UPDATE client_blocks SET client_id = $1 WHERE client_id = ANY($2)",
+		// Резерв дубля на издание, которое уже зарезервировано сохраняемой записью, отменяется
+		`UPDATE holds SET status = 'cancelled', closed_at = NOW()
+        WHERE client_id = ANY($2) AND status IN ('waiting', 'ready')
+          AND (book_id IN (SELECT book_id FROM holds WHERE client_id = $1 AND status IN ('waiting', 'ready'))
+               OR id <> (SELECT MIN(d.id) FROM holds d
+                         WHERE d.client_id = ANY($2) AND d.book_id = holds.book_id AND d.status IN ('waiting', 'ready')))`,
+		"UPDATE holds SET client_id = $1 WHERE client_id = ANY($2)",
+		"UPDATE membership_renewals SET client_id = $1 WHERE client_id = ANY($2)",
+		"UPDATE fine_receipts SET client_id = $1 WHERE client_id = ANY($2)",
+		"UPDATE fine_ledger SET client_id = $1 WHERE client_id = ANY($2)",
+		// Срок членства - самый поздний из объединяемых
+		`UPDATE clients SET
+            membership_start = (SELECT MIN(membership_start) FROM clients WHERE id = ANY($2) OR id = $1),
+            membership_expires = (SELECT MAX(membership_expires) FROM clients WHERE id = ANY($2) OR id = $1)
+        WHERE id = $1`,
+		// Действующим остается билет сохраняемой записи, а если его нет - самый новый из дублей
+		`UPDATE library_cards SET status = 'replaced', replaced_at = NOW()
+        WHERE client_id = ANY($2) AND status = 'active'
+          AND (EXISTS (SELECT 1 FROM library_cards WHERE client_id = $1 AND status = 'active')
+               OR id <> (SELECT id FROM library_cards WHERE client_id = ANY($2) AND status = 'active' ORDER BY issued_at DESC LIMIT 1))`,
+		"UPDATE library_cards SET client_id = $1 WHERE client_id = ANY($2)",
+		"UPDATE client_merges SET survivor_id = $1 WHERE survivor_id = ANY($2)",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, request.SurvivorID, dupIDs); err != nil {
+			log.Println("Ошибка переноса ссылок на дубли:", err)
+			http.Error(w, "Error merging clients", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM clients WHERE id = ANY($1)", dupIDs); err != nil {
+		log.Println("Ошибка удаления дублей:", err)
+		http.Error(w, "Error deleting duplicates", http.StatusInternalServerError)
+		return
+	}
+
+	var mergeID int
+	err = tx.QueryRow(`
+        INSERT INTO client_merges (survivor_id, merged_ids, merged_names, moved_loans, reason, merged_by)
+        VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''))
+        RETURNING id`,
+		request.SurvivorID, dupIDs, pq.Array(mergedNames), movedLoans, request.Reason, currentUsername(r)).Scan(&mergeID)
+	if err != nil {
+		http.Error(w, "Error recording merge", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		MergeID    int   `json:"merge_id"`
+		SurvivorID int   `json:"survivor_id"`
+		MovedLoans int64 `json:"moved_loans"`
+	}{
+		MergeID:    mergeID,
+		SurvivorID: request.SurvivorID,
+		MovedLoans: movedLoans,
+	})
+}
+
+type ClientMerge struct {
+	ID          int      `json:"id"`
+	SurvivorID  int      `json:"survivor_id"`
+	MergedIDs   []int64  `json:"merged_ids"`
+	MergedNames []string `json:"merged_names"`
+	MovedLoans  int      `json:"moved_loans"`
+	Reason      string   `json:"reason"`
+	MergedBy    string   `json:"merged_by"`
+	MergedAt    string   `json:"merged_at"`
+}
+
+func GetClientMerges(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.DB.Query(`
+        SELECT id, survivor_id, merged_ids, merged_names, moved_loans,
+               COALESCE(reason, ''), COALESCE(merged_by, ''), merged_at
+        FROM client_merges
+        ORDER BY merged_at DESC`)
+	if err != nil {
+		http.Error(w, "Error fetching merges", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var merges []ClientMerge
+	for rows.Next() {
+		var m ClientMerge
+		err := rows.Scan(&m.ID, &m.SurvivorID, pq.Array(&m.MergedIDs), pq.Array(&m.MergedNames), &m.MovedLoans, &m.Reason, &m.MergedBy, &m.MergedAt)
+		if err != nil {
+			http.Error(w, "Error scanning merges", http.StatusInternalServerError)
+			return
+		}
+		merges = append(merges, m)
+	}
+
+	json.NewEncoder(w).Encode(merges)
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/mail"
+	"strings"
+	"unicode"
+)
+
+// Каналы связи с клиентом
+var notificationChannels = map[string]bool{"email": true, "sms": true, "post": true}
+
+// Типы уведомлений, на которые клиент может подписаться или от которых может отказаться
+var notificationTypes = []string{"due_soon", "overdue", "hold_available", "fines"}
+
+// Нормализация телефона к виду +79991234567; российский номер с 8 приводится к +7
+func normalizePhone(s string) string {
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, s)
+	if digits == "" {
+		return ""
+	}
+	if len(digits) == 11 && digits[0] == '8' {
+		digits = "7" + digits[1:]
+	}
+	if len(digits) == 10 && !strings.HasPrefix(strings.TrimSpace(s), "+") {
+		digits = "7" + digits
+	}
+	return "+" + digits
+}
+
+func validateContacts(client *Client) []FieldError {
+	var errs []FieldError
+
+	client.Email = strings.TrimSpace(client.Email)
+	client.Address = strings.TrimSpace(client.Address)
+	client.Phone = normalizePhone(client.Phone)
+
+	if client.Email != "" {
+		if addr, err := mail.ParseAddress(client.Email); err != nil || addr.Address != client.Email {
+			errs = append(errs, FieldError{"email", "is not a valid email address"})
+		}
+	}
+	if client.Phone != "" && (len(client.Phone) < 11 || len(client.Phone) > 16) {
+		errs = append(errs, FieldError{"phone", "must contain 10-15 digits"})
+	}
+
+	seen := map[string]bool{}
+	channels := []string{}
+	for _, channel := range client.NotificationChannels {
+		channel = strings.ToLower(strings.TrimSpace(channel))
+		if !notificationChannels[channel] {
+			errs = append(errs, FieldError{"notification_channels", "unknown channel " + channel})
+			continue
+		}
+		if seen[channel] {
+			continue
+		}
+		seen[channel] = true
+		channels = append(channels, channel)
+
+		// Канал без контакта бесполезен
+		switch {
+		case channel == "email" && client.Email == "":
+			errs = append(errs, FieldError{"email", "is required for the email channel"})
+		case channel == "sms" && client.Phone == "":
+			errs = append(errs, FieldError{"phone", "is required for the sms channel"})
+		case channel == "post" && client.Address == "":
+			errs = append(errs, FieldError{"address", "is required for the post channel"})
+		}
+	}
+	client.NotificationChannels = channels
+
+	for notificationType := range client.Notifications {
+		if !validNotificationType(notificationType) {
+			errs = append(errs, FieldError{"notifications", "unknown notification type " + notificationType})
+		}
+	}
+
+	return errs
+}
+
+func validNotificationType(t string) bool {
+	for _, known := range notificationTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Настройки уведомлений клиента; для типов без явной записи - согласие
+func loadNotificationPrefs(q queryer, clientID int) (map[string]bool, error) {
+	prefs := make(map[string]bool, len(notificationTypes))
+	for _, t := range notificationTypes {
+		prefs[t] = true
+	}
+
+	rows, err := q.Query("SELECT notification_type, opt_in FROM client_notification_prefs WHERE client_id = $1", clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t string
+		var optIn bool
+		if err := rows.Scan(&t, &optIn); err != nil {
+			return nil, err
+		}
+		prefs[t] = optIn
+	}
+	return prefs, rows.Err()
+}
+
+// Сохраняет только переданные типы, остальные настройки не меняются
+func saveNotificationPrefs(tx *sql.Tx, clientID int, prefs map[string]bool) error {
+	query := `
+        INSERT INTO client_notification_prefs (client_id, notification_type, opt_in)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (client_id, notification_type) DO UPDATE SET opt_in = EXCLUDED.opt_in`
+	for t, optIn := range prefs {
+		if _, err := tx.Exec(query, clientID, t, optIn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"library-backend/db"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+type ExportLoan struct {
+	JournalID int             `json:"journal_id"`
+	BookID    int             `json:"book_id"`
+	BookName  string          `json:"book_name"`
+	DateBeg   string          `json:"date_beg"`
+	DateEnd   string          `json:"date_end"`
+	DateRet   string          `json:"date_ret"`
+	Fine      decimal.Decimal `json:"fine"`
+}
+
+// Все персональные данные клиента, которые хранит библиотека
+type ClientExport struct {
+	ExportedAt    string          `json:"exported_at"`
+	Profile       Client          `json:"profile"`
+	Membership    Membership      `json:"membership"`
+	Cards         []LibraryCard   `json:"cards"`
+	Loans         []ExportLoan    `json:"loans"`
+	Fines         []FineEntry     `json:"fines"`
+	Receipts      []FineReceipt   `json:"receipts"`
+	Notifications map[string]bool `json:"notifications"`
+	InHouseUses   []InHouseUse    `json:"in_house_uses"`
+	Blocks        []ClientBlock   `json:"blocks"`
+	Holds         []Hold          `json:"holds"`
+}
+
+func collectClientExport(clientID int) (ClientExport, error) {
+	export := ClientExport{ExportedAt: time.Now().Format(time.RFC3339)}
+
+	var err error
+	export.Profile, err = scanClient(db.DB.QueryRow("SELECT "+clientColumns+" FROM clients WHERE id = $1", clientID), true)
+	if err != nil {
+		return export, err
+	}
+	if export.Membership, err = loadMembership(db.DB, clientID); err != nil {
+		return export, err
+	}
+	if export.Cards, err = loadClientCards(db.DB, clientID); err != nil {
+		return export, err
+	}
+	if export.Notifications, err = loadNotificationPrefs(db.DB, clientID); err != nil {
+		return export, err
+	}
+	if export.Blocks, err = loadClientBlocks(db.DB, clientID); err != nil {
+		return export, err
+	}
+	if export.Holds, err = loadClientHolds(db.DB, clientID, false); err != nil {
+		return export, err
+	}
+	if export.Fines, err = loadFineEntries(db.DB, clientID); err != nil {
+		return export, err
+	}
+	if export.Receipts, err = loadClientReceipts(db.DB, clientID); err != nil {
+		return export, err
+	}
+
+	rows, err := db.DB.Query(`
+        SELECT j.id, j.book_id, b.name, j.date_beg::text, j.date_end::text, COALESCE(j.date_ret::text, ''), COALESCE(j.fine_today, 0)
+        FROM journal j
+        JOIN books b ON j.book_id = b.id
+        WHERE j.client_id = $1
+        ORDER BY j.date_beg`, clientID)
+	if err != nil {
+		return export, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var loan ExportLoan
+		if err := rows.Scan(&loan.JournalID, &loan.BookID, &loan.BookName, &loan.DateBeg, &loan.DateEnd, &loan.DateRet, &loan.Fine); err != nil {
+			return export, err
+		}
+		export.Loans = append(export.Loans, loan)
+	}
+	if err := rows.Err(); err != nil {
+		return export, err
+	}
+
+	useRows, err := db.DB.Query(`
+        SELECT id, book_id, client_id, used_at::text, COALESCE(returned_at::text, '')
+        FROM in_house_uses
+        WHERE client_id = $1
+        ORDER BY used_at`, clientID)
+	if err != nil {
+		return export, err
+	}
+	defer useRows.Close()
+	for useRows.Next() {
+		var use InHouseUse
+		if err := useRows.Scan(&use.ID, &use.BookID, &use.ClientID, &use.UsedAt, &use.ReturnedAt); err != nil {
+			return export, err
+		}
+		export.InHouseUses = append(export.InHouseUses, use)
+	}
+	return export, useRows.Err()
+}
+
+// Выгрузка персональных данных клиента по запросу субъекта.
+// По умолчанию JSON, с ?format=zip - архив с отдельным файлом на каждый раздел.
+func ExportClientData(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	export, err := collectClientExport(clientID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Ошибка выгрузки данных клиента:", err)
+		http.Error(w, "Error exporting client data", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Выгрузка персональных данных клиента %d, запросил %s", clientID, currentUsername(r))
+
+	name := "client-" + strconv.Itoa(clientID)
+	if r.URL.Query().Get("format") != "zip" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.json"`)
+		json.NewEncoder(w).Encode(export)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.zip"`)
+	archive := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", export.Profile},
+		{"membership.json", export.Membership},
+		{"cards.json", export.Cards},
+		{"loans.json", export.Loans},
+		{"fines.json", export.Fines},
+		{"receipts.json", export.Receipts},
+		{"notifications.json", export.Notifications},
+		{"in_house_uses.json", export.InHouseUses},
+		{"blocks.json", export.Blocks},
+		{"holds.json", export.Holds},
+	}
+	for _, file := range files {
+		f, err := archive.Create(name + "/" + file.name)
+		if err != nil {
+			log.Println("Ошибка формирования архива:", err)
+			return
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			log.Println("Ошибка формирования архива:", err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Println("Ошибка формирования архива:", err)
+	}
+}
+
+// Обезличивание клиента: персональные данные стираются, строка клиента и журнал
+// остаются, чтобы не искажать статистику выдачи.
+func AnonymizeClient(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var anonymized bool
+	err = tx.QueryRow("SELECT anonymized_at IS NOT NULL FROM clients WHERE id = $1 FOR UPDATE", clientID).Scan(&anonymized)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if anonymized {
+		http.Error(w, "Client is already anonymized", http.StatusConflict)
+		return
+	}
+
+	// Пока книги на руках, клиент должен оставаться узнаваемым
+	var onHand int
+	err = tx.QueryRow("SELECT COUNT(*) FROM journal WHERE client_id = $1 AND date_ret IS NULL", clientID).Scan(&onHand)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if onHand > 0 {
+		http.Error(w, "Client has books on hand", http.StatusConflict)
+		return
+	}
+
+	_, err = tx.Exec(`
+        UPDATE clients SET
+            first_name = '', last_name = 'Обезличен', father_name = '',
+            passport_seria = NULL, passport_number = NULL,
+            passport_seria_enc = NULL, passport_number_enc = NULL, passport_dek = NULL,
+            document_bidx = NULL, passport_number_bidx = NULL,
+            email = NULL, phone = NULL, address = NULL, notification_channels = '{}',
+            search_name = '', search_latin = '',
+            anonymized_at = NOW(), anonymized_by = NULLIF($2, '')
+        WHERE id = $1`, clientID, currentUsername(r))
+	if err != nil {
+		log.Println("Ошибка обезличивания клиента:", err)
+		http.Error(w, "Error anonymizing client", http.StatusInternalServerError)
+		return
+	}
+
+	// Номера билетов и причины блокировок позволяют узнать человека
+	for _, query := range []string{
+		"DELETE FROM library_cards WHERE client_id = $1",
+		"DELETE FROM client_blocks WHERE client_id = $1",
+		"DELETE FROM client_notification_prefs WHERE client_id = $1",
+		"DELETE FROM patron_credentials WHERE client_id = $1",
+		"UPDATE holds SET status = 'cancelled', closed_at = NOW() WHERE client_id = $1 AND status IN ('waiting', 'ready')",
+		"UPDATE clients SET loan_history_opt_in = FALSE WHERE id = $1",
+		"UPDATE client_merges SET merged_names = '{}' WHERE survivor_id = $1",
+	} {
+		if _, err := tx.Exec(query, clientID); err != nil {
+			log.Println("Ошибка обезличивания клиента:", err)
+			http.Error(w, "Error anonymizing client", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Клиент %d обезличен, выполнил %s", clientID, currentUsername(r))
+	w.Write([]byte("Client anonymized successfully"))
+}
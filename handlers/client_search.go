@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"encoding/json"
+	"library-backend/db"
+	"library-backend/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type ClientSearchResult struct {
+	ID         int     `json:"id"`
+	FirstName  string  `json:"first_name"`
+	LastName   string  `json:"last_name"`
+	FatherName string  `json:"father_name"`
+	CardNumber string  `json:"card_number"`
+	Match      string  `json:"match"`
+	Score      float64 `json:"score"`
+}
+
+// Облегченный результат для автодополнения
+type ClientSuggestion struct {
+	ID         int    `json:"id"`
+	Label      string `json:"label"`
+	CardNumber string `json:"card_number"`
+}
+
+// Нормализация для поиска: нижний регистр, ё -> е, одиночные пробелы
+func normalizeSearchText(s string) string {
+	s = strings.ReplaceAll(strings.ToLower(s), "ё", "е")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// Поисковые поля клиента: ФИО в нормализованном виде и в транслитерации
+func clientSearchFields(client Client) (string, string) {
+	name := normalizeSearchText(client.LastName + " " + client.FirstName + " " + client.FatherName)
+	return name, strings.ToLower(utils.Transliterate(name))
+}
+
+// Заполнение поисковых полей для клиентов, сохраненных до их появления
+func BackfillClientSearch() error {
+	rows, err := db.DB.Query("SELECT id, first_name, last_name, father_name FROM clients WHERE search_name IS NULL AND anonymized_at IS NULL")
+	if err != nil {
+		return err
+	}
+
+	var clients []Client
+	for rows.Next() {
+		var client Client
+		if err := rows.Scan(&client.ID, &client.FirstName, &client.LastName, &client.FatherName); err != nil {
+			rows.Close()
+			return err
+		}
+		clients = append(clients, client)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, client := range clients {
+		name, latin := clientSearchFields(client)
+		if _, err := db.DB.Exec("UPDATE clients SET search_name = $1, search_latin = $2 WHERE id = $3", name, latin, client.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Поиск клиентов: GET /clients/search?q=...&limit=20&mode=autocomplete.
+// Номер билета и номер документа ищутся по точному совпадению, ФИО - по префиксу
+// и нечетко (триграммы), в том числе латиницей.
+func SearchClients(w http.ResponseWriter, r *http.Request) {
+	raw := strings.TrimSpace(r.URL.Query().Get("q"))
+	query := normalizeSearchText(raw)
+	if len([]rune(query)) < 2 {
+		http.Error(w, "Query must be at least 2 characters", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	if r.URL.Query().Get("mode") == "autocomplete" {
+		suggestClients(w, query, min(limit, 10))
+		return
+	}
+
+	var results []ClientSearchResult
+	seen := map[int]bool{}
+	addExact := func(match string, sql string, args ...any) bool {
+		rows, err := db.DB.Query(sql, args...)
+		if err != nil {
+			log.Println("Ошибка поиска клиента:", err)
+			http.Error(w, "Error searching clients", http.StatusInternalServerError)
+			return false
+		}
+		defer rows.Close()
+		for rows.Next() {
+			res := ClientSearchResult{Match: match, Score: 2}
+			if err := rows.Scan(&res.ID, &res.FirstName, &res.LastName, &res.FatherName, &res.CardNumber); err != nil {
+				http.Error(w, "Error scanning clients", http.StatusInternalServerError)
+				return false
+			}
+			if !seen[res.ID] {
+				seen[res.ID] = true
+				results = append(results, res)
+			}
+		}
+		return true
+	}
+
+	const exactColumns = `
+        SELECT c.id, c.first_name, c.last_name, c.father_name, COALESCE(lc.card_number, '')
+        FROM clients c
+        LEFT JOIN library_cards lc ON lc.client_id = c.id AND lc.status = 'active'`
+
+	compact := normalizeCardNumber(normalizeDocumentField(raw))
+	if isDigits(compact) && utils.ValidLuhn(compact) && strings.HasPrefix(compact, cardPrefix) {
+		if !addExact("card", exactColumns+" WHERE lc.card_number = $1", compact) {
+			return
+		}
+	}
+
+	if len(compact) >= 5 {
+		// Серия и номер, набранные подряд: номер паспорта РФ - последние 6 цифр
+		candidates := [][]byte{passportNumberBlindIndex(compact)}
+		if isDigits(compact) && len(compact) == 10 {
+			candidates = append(candidates, passportNumberBlindIndex(compact[4:]))
+		}
+		for _, bidx := range candidates {
+			if !addExact("document", exactColumns+" WHERE c.passport_number_bidx = $1", bidx) {
+				return
+			}
+		}
+	}
+
+	// Запрос из одних цифр - это номер, а не имя
+	if !isDigits(compact) {
+		pattern := escapeLike(query)
+		rows, err := db.DB.Query(`
+            SELECT c.id, c.first_name, c.last_name, c.father_name, COALESCE(lc.card_number, ''),
+                   CASE
+                       WHEN c.search_name LIKE $1 || '%' OR c.search_latin LIKE $1 || '%' THEN 'prefix'
+                       WHEN c.search_name LIKE '% ' || $1 || '%' OR c.search_latin LIKE '% ' || $1 || '%' THEN 'word_prefix'
+                       ELSE 'fuzzy'
+                   END AS match,
+                   GREATEST(
+                       CASE
+                           WHEN c.search_name LIKE $1 || '%' OR c.search_latin LIKE $1 || '%' THEN 1.0
+                           WHEN c.search_name LIKE '% ' || $1 || '%' OR c.search_latin LIKE '% ' || $1 || '%' THEN 0.9
+                           ELSE 0
+                       END,
+                       word_similarity($2, c.search_name),
+                       word_similarity($2, c.search_latin)
+                   ) AS score
+            FROM clients c
+            LEFT JOIN library_cards lc ON lc.client_id = c.id AND lc.status = 'active'
+            WHERE c.search_name LIKE $1 || '%' OR c.search_latin LIKE $1 || '%'
+               OR c.search_name LIKE '% ' || $1 || '%' OR c.search_latin LIKE '% ' || $1 || '%'
+               OR $2 <% c.search_name OR $2 <% c.search_latin
+            ORDER BY score DESC, c.last_name, c.first_name
+            LIMIT $3`, pattern, query, limit)
+		if err != nil {
+			log.Println("Ошибка поиска клиента:", err)
+			http.Error(w, "Error searching clients", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var res ClientSearchResult
+			if err := rows.Scan(&res.ID, &res.FirstName, &res.LastName, &res.FatherName, &res.CardNumber, &res.Match, &res.Score); err != nil {
+				http.Error(w, "Error scanning clients", http.StatusInternalServerError)
+				return
+			}
+			if !seen[res.ID] {
+				seen[res.ID] = true
+				results = append(results, res)
+			}
+		}
+	}
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// Автодополнение: только префиксные совпадения по индексу, без нечеткого поиска
+func suggestClients(w http.ResponseWriter, query string, limit int) {
+	pattern := escapeLike(query)
+	rows, err := db.DB.Query(`
+        SELECT c.id, c.last_name || ' ' || c.first_name || COALESCE(' ' || NULLIF(c.father_name, ''), ''),
+               COALESCE(lc.card_number, '')
+        FROM clients c
+        LEFT JOIN library_cards lc ON lc.client_id = c.id AND lc.status = 'active'
+        WHERE c.search_name LIKE $1 || '%' OR c.search_latin LIKE $1 || '%'
+        ORDER BY c.last_name, c.first_name
+        LIMIT $2`, pattern, limit)
+	if err != nil {
+		log.Println("Ошибка поиска клиента:", err)
+		http.Error(w, "Error searching clients", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var suggestions []ClientSuggestion
+	for rows.Next() {
+		var s ClientSuggestion
+		if err := rows.Scan(&s.ID, &s.Label, &s.CardNumber); err != nil {
+			http.Error(w, "Error scanning clients", http.StatusInternalServerError)
+			return
+		}
+		suggestions = append(suggestions, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suggestions)
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import "testing"
+
+func TestClientSearchFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		client   Client
+		wantName string
+		wantLat  string
+	}{
+		{
+			name:     "full name",
+			client:   Client{LastName: "Пушкин", FirstName: "Александр", FatherName: "Сергеевич"},
+			wantName: "пушкин александр сергеевич",
+			wantLat:  "pushkin aleksandr sergeevich",
+		},
+		{
+			name:     "yo and extra spaces",
+			client:   Client{LastName: "  Королёв ", FirstName: "Сергей  Павлович"},
+			wantName: "королев сергей павлович",
+			wantLat:  "korolev sergey pavlovich",
+		},
+		{
+			name:     "no patronymic",
+			client:   Client{LastName: "ЩЕРБАКОВА", FirstName: "Юлия"},
+			wantName: "щербакова юлия",
+			wantLat:  "shcherbakova yuliya",
+		},
+		{
+			name:     "latin name",
+			client:   Client{LastName: "Smith", FirstName: "John"},
+			wantName: "smith john",
+			wantLat:  "smith john",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, lat := clientSearchFields(tt.client)
+			if name != tt.wantName || lat != tt.wantLat {
+				t.Errorf("clientSearchFields = %q, %q; want %q, %q", name, lat, tt.wantName, tt.wantLat)
+			}
+		})
+	}
+}
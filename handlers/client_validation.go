@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Правила для типа документа: формат серии и номера после нормализации
+type documentRule struct {
+	Seria       *regexp.Regexp
+	Number      *regexp.Regexp
+	SeriaHint   string
+	NumberHint  string
+	SeriaNeeded bool
+}
+
+var documentRules = map[string]documentRule{
+	// Внутренний паспорт РФ: серия 4 цифры, номер 6 цифр
+	"ru_passport": {
+		Seria: regexp.MustCompile(`^\d{4}$`), Number: regexp.MustCompile(`^\d{6}$`),
+		SeriaHint: "must be 4 digits", NumberHint: "must be 6 digits", SeriaNeeded: true,
+	},
+	// Заграничный паспорт РФ: серия 2 цифры, номер 7 цифр
+	"ru_foreign_passport": {
+		Seria: regexp.MustCompile(`^\d{2}$`), Number: regexp.MustCompile(`^\d{7}$`),
+		SeriaHint: "must be 2 digits", NumberHint: "must be 7 digits", SeriaNeeded: true,
+	},
+	// Паспорт иностранного гражданина: формат зависит от страны
+	"foreign_passport": {
+		Seria: regexp.MustCompile(`^[A-Z0-9]{0,10}$`), Number: regexp.MustCompile(`^[A-Z0-9]{5,20}$`),
+		SeriaHint: "must be up to 10 letters or digits", NumberHint: "must be 5-20 letters or digits",
+	},
+	// Студенческий билет
+	"student_id": {
+		Seria: regexp.MustCompile(`^[A-ZА-Я0-9]{0,10}$`), Number: regexp.MustCompile(`^[A-ZА-Я0-9]{1,20}$`),
+		SeriaHint: "must be up to 10 letters or digits", NumberHint: "must be 1-20 letters or digits",
+	},
+}
+
+const defaultDocumentType = "ru_passport"
+
+// Нормализация реквизитов документа: без пробелов и дефисов, в верхнем регистре
+func normalizeDocumentField(s string) string {
+	return strings.ToUpper(strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '-' {
+			return -1
+		}
+		return r
+	}, s))
+}
+
+func validName(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) && r != '-' && r != ' ' && r != '\'' {
+			return false
+		}
+	}
+	return true
+}
+
+// Нормализует данные клиента на месте и возвращает ошибки по всем полям сразу
+func validateClient(client *Client) []FieldError {
+	return append(validateClientProfile(client), validateDocument(client)...)
+}
+
+// Проверка всего, кроме документа: ФИО и контакты
+func validateClientProfile(client *Client) []FieldError {
+	var errs []FieldError
+
+	client.FirstName = strings.TrimSpace(client.FirstName)
+	client.LastName = strings.TrimSpace(client.LastName)
+	client.FatherName = strings.TrimSpace(client.FatherName)
+
+	if client.LastName == "" {
+		errs = append(errs, FieldError{"last_name", "is required"})
+	} else if !validName(client.LastName) {
+		errs = append(errs, FieldError{"last_name", "may contain only letters, spaces, hyphens and apostrophes"})
+	}
+	if client.FirstName == "" {
+		errs = append(errs, FieldError{"first_name", "is required"})
+	} else if !validName(client.FirstName) {
+		errs = append(errs, FieldError{"first_name", "may contain only letters, spaces, hyphens and apostrophes"})
+	}
+	if !validName(client.FatherName) {
+		errs = append(errs, FieldError{"father_name", "may contain only letters, spaces, hyphens and apostrophes"})
+	}
+
+	return append(errs, validateContacts(client)...)
+}
+
+func validateDocument(client *Client) []FieldError {
+	var errs []FieldError
+	if client.DocumentType == "" {
+		client.DocumentType = defaultDocumentType
+	}
+	rule, ok := documentRules[client.DocumentType]
+	if !ok {
+		errs = append(errs, FieldError{"document_type", "unknown document type"})
+		return errs
+	}
+
+	client.PassportSeria = normalizeDocumentField(client.PassportSeria)
+	client.PassportNumber = normalizeDocumentField(client.PassportNumber)
+
+	if client.PassportSeria == "" && rule.SeriaNeeded {
+		errs = append(errs, FieldError{"passport_seria", "is required"})
+	} else if !rule.Seria.MatchString(client.PassportSeria) {
+		errs = append(errs, FieldError{"passport_seria", rule.SeriaHint})
+	}
+	if client.PassportNumber == "" {
+		errs = append(errs, FieldError{"passport_number", "is required"})
+	} else if !rule.Number.MatchString(client.PassportNumber) {
+		errs = append(errs, FieldError{"passport_number", rule.NumberHint})
+	}
+
+	return errs
+}
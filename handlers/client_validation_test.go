@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestValidateDocument(t *testing.T) {
+	tests := []struct {
+		name       string
+		client     Client
+		wantType   string
+		wantSeria  string
+		wantNumber string
+		errs       []FieldError
+	}{
+		{
+			name:       "passport with default type",
+			client:     Client{PassportSeria: "45 01", PassportNumber: "123456"},
+			wantType:   "ru_passport",
+			wantSeria:  "4501",
+			wantNumber: "123456",
+		},
+		{
+			name:       "passport with spaces and hyphens",
+			client:     Client{DocumentType: "ru_passport", PassportSeria: " 45-01 ", PassportNumber: "123-456"},
+			wantType:   "ru_passport",
+			wantSeria:  "4501",
+			wantNumber: "123456",
+		},
+		{
+			name:     "passport without seria",
+			client:   Client{PassportNumber: "123456"},
+			wantType: "ru_passport",
+			errs:     []FieldError{{"passport_seria", "is required"}},
+		},
+		{
+			name:     "passport with short number",
+			client:   Client{PassportSeria: "4501", PassportNumber: "12345"},
+			wantType: "ru_passport",
+			errs:     []FieldError{{"passport_number", "must be 6 digits"}},
+		},
+		{
+			name:     "passport with letters",
+			client:   Client{PassportSeria: "45AB", PassportNumber: ""},
+			wantType: "ru_passport",
+			errs:     []FieldError{{"passport_seria", "must be 4 digits"}, {"passport_number", "is required"}},
+		},
+		{
+			name:       "foreign passport of the Russian Federation",
+			client:     Client{DocumentType: "ru_foreign_passport", PassportSeria: "75", PassportNumber: "1234567"},
+			wantType:   "ru_foreign_passport",
+			wantSeria:  "75",
+			wantNumber: "1234567",
+		},
+		{
+			name:     "foreign passport of the Russian Federation with passport number",
+			client:   Client{DocumentType: "ru_foreign_passport", PassportSeria: "4501", PassportNumber: "123456"},
+			wantType: "ru_foreign_passport",
+			errs:     []FieldError{{"passport_seria", "must be 2 digits"}, {"passport_number", "must be 7 digits"}},
+		},
+		{
+			name:       "passport of a foreign citizen without seria",
+			client:     Client{DocumentType: "foreign_passport", PassportNumber: "c01x00t47"},
+			wantType:   "foreign_passport",
+			wantNumber: "C01X00T47",
+		},
+		{
+			name:     "passport of a foreign citizen with punctuation",
+			client:   Client{DocumentType: "foreign_passport", PassportNumber: "C01/X00"},
+			wantType: "foreign_passport",
+			errs:     []FieldError{{"passport_number", "must be 5-20 letters or digits"}},
+		},
+		{
+			name:       "student ID with cyrillic letters",
+			client:     Client{DocumentType: "student_id", PassportSeria: "мгу", PassportNumber: "ст-2024"},
+			wantType:   "student_id",
+			wantSeria:  "МГУ",
+			wantNumber: "СТ2024",
+		},
+		{
+			name:     "unknown document type",
+			client:   Client{DocumentType: "driving_license", PassportNumber: "123456"},
+			wantType: "driving_license",
+			errs:     []FieldError{{"document_type", "unknown document type"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := tt.client
+			errs := validateDocument(&client)
+			if !slices.Equal(errs, tt.errs) {
+				t.Fatalf("errors = %v, want %v", errs, tt.errs)
+			}
+			if client.DocumentType != tt.wantType {
+				t.Errorf("document_type = %q, want %q", client.DocumentType, tt.wantType)
+			}
+			if len(tt.errs) == 0 && (client.PassportSeria != tt.wantSeria || client.PassportNumber != tt.wantNumber) {
+				t.Errorf("seria/number = %q/%q, want %q/%q", client.PassportSeria, client.PassportNumber, tt.wantSeria, tt.wantNumber)
+			}
+		})
+	}
+}
+
+func TestValidateClientProfileNames(t *testing.T) {
+	tests := []struct {
+		name   string
+		client Client
+		errs   []FieldError
+	}{
+		{
+			name:   "full name",
+			client: Client{LastName: " Салтыков-Щедрин ", FirstName: "Михаил", FatherName: "Евграфович"},
+		},
+		{
+			name:   "apostrophe and no patronymic",
+			client: Client{LastName: "O'Brien", FirstName: "Anne Marie"},
+		},
+		{
+			name:   "missing names",
+			client: Client{LastName: "  ", FatherName: "Иванович"},
+			errs:   []FieldError{{"last_name", "is required"}, {"first_name", "is required"}},
+		},
+		{
+			name:   "digits and punctuation",
+			client: Client{LastName: "Иванов2", FirstName: "Иван", FatherName: "Ив."},
+			errs: []FieldError{
+				{"last_name", "may contain only letters, spaces, hyphens and apostrophes"},
+				{"father_name", "may contain only letters, spaces, hyphens and apostrophes"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := tt.client
+			if errs := validateClientProfile(&client); !slices.Equal(errs, tt.errs) {
+				t.Errorf("errors = %v, want %v", errs, tt.errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"library-backend/db"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+type EligibilityRules struct {
+	// null - без ограничения по сумме штрафов
+	MaxOutstandingFine decimal.NullDecimal `json:"max_outstanding_fine"`
+	BlockOnOverdue     bool                `json:"block_on_overdue"`
+	OverdueGraceDays   int                 `json:"overdue_grace_days"`
+}
+
+type ClientBlock struct {
+	ID        int    `json:"id"`
+	ClientID  int    `json:"client_id"`
+	Reason    string `json:"reason"`
+	ExpiresAt string `json:"expires_at"`
+	CreatedBy string `json:"created_by"`
+	CreatedAt string `json:"created_at"`
+	LiftedAt  string `json:"lifted_at"`
+	LiftedBy  string `json:"lifted_by"`
+}
+
+// Причина отказа в выдаче
+type IneligibilityReason struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	BlockID    int    `json:"block_id,omitempty"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
+	JournalIDs []int  `json:"journal_ids,omitempty"`
+}
+
+type Eligibility struct {
+	ClientID        int                   `json:"client_id"`
+	Eligible        bool                  `json:"eligible"`
+	OutstandingFine decimal.Decimal       `json:"outstanding_fine"`
+	OverdueItems    int                   `json:"overdue_items"`
+	Reasons         []IneligibilityReason `json:"reasons"`
+}
+
+func loadEligibilityRules(q queryer) (EligibilityRules, error) {
+	var rules EligibilityRules
+	err := q.QueryRow("SELECT max_outstanding_fine, block_on_overdue, overdue_grace_days FROM eligibility_rules WHERE id = 1").
+		Scan(&rules.MaxOutstandingFine, &rules.BlockOnOverdue, &rules.OverdueGraceDays)
+	if err == sql.ErrNoRows {
+		// Правила не настроены - ограничений нет
+		return rules, nil
+	}
+	return rules, err
+}
+
+// Неоплаченные штрафы клиента: остаток по проводкам учета штрафов
+func clientOutstandingFine(q queryer, clientID int) (decimal.Decimal, error) {
+	var total decimal.Decimal
+	err := q.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM fine_ledger WHERE client_id = $1", clientID).Scan(&total)
+	return total, err
+}
+
+// Проверка всех правил допуска к выдаче. Возвращает sql.ErrNoRows, если клиента нет.
+func evaluateEligibility(q queryer, clientID int) (Eligibility, error) {
+	result := Eligibility{ClientID: clientID, Reasons: []IneligibilityReason{}}
+
+	var membershipExpires sql.NullString
+	var active, anonymized bool
+	err := q.QueryRow(`
+        SELECT membership_expires::text, membership_expires IS NULL OR membership_expires >= CURRENT_DATE,
+               anonymized_at IS NOT NULL
+        FROM clients WHERE id = $1`, clientID).Scan(&membershipExpires, &active, &anonymized)
+	if err != nil {
+		return result, err
+	}
+	if anonymized {
+		result.Reasons = append(result.Reasons, IneligibilityReason{Code: "anonymized", Message: "Client record is anonymized"})
+		return result, nil
+	}
+	if !active {
+		result.Reasons = append(result.Reasons, IneligibilityReason{
+			Code:      "membership_expired",
+			Message:   "Membership expired on " + membershipExpires.String,
+			ExpiresAt: membershipExpires.String,
+		})
+	}
+
+	rules, err := loadEligibilityRules(q)
+	if err != nil {
+		return result, err
+	}
+
+	result.OutstandingFine, err = clientOutstandingFine(q, clientID)
+	if err != nil {
+		return result, err
+	}
+	if rules.MaxOutstandingFine.Valid && result.OutstandingFine.GreaterThan(rules.MaxOutstandingFine.Decimal) {
+		result.Reasons = append(result.Reasons, IneligibilityReason{
+			Code:    "fines",
+			Message: fmt.Sprintf("Outstanding fines %s exceed the limit of %s", result.OutstandingFine.StringFixed(2), rules.MaxOutstandingFine.Decimal.StringFixed(2)),
+		})
+	}
+
+	var overdue []int64
+	err = q.QueryRow(`
+        SELECT COALESCE(array_agg(id ORDER BY date_end), '{}')
+        FROM journal
+        WHERE client_id = $1 AND date_ret IS NULL AND date_end < CURRENT_DATE - $2::int`,
+		clientID, rules.OverdueGraceDays).Scan(pq.Array(&overdue))
+	if err != nil {
+		return result, err
+	}
+	result.OverdueItems = len(overdue)
+	if rules.BlockOnOverdue && len(overdue) > 0 {
+		ids := make([]int, len(overdue))
+		for i, id := range overdue {
+			ids[i] = int(id)
+		}
+		result.Reasons = append(result.Reasons, IneligibilityReason{
+			Code:       "overdue",
+			Message:    fmt.Sprintf("%d overdue item(s) must be returned first", len(ids)),
+			JournalIDs: ids,
+		})
+	}
+
+	rows, err := q.Query(`
+        SELECT id, reason, COALESCE(expires_at::text, '')
+        FROM client_blocks
+        WHERE client_id = $1 AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+        ORDER BY created_at`, clientID)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		reason := IneligibilityReason{Code: "manual_block"}
+		if err := rows.Scan(&reason.BlockID, &reason.Message, &reason.ExpiresAt); err != nil {
+			return result, err
+		}
+		reason.Message = "Blocked: " + reason.Message
+		result.Reasons = append(result.Reasons, reason)
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	result.Eligible = len(result.Reasons) == 0
+	return result, nil
+}
+
+// Сообщение об отказе для ответов, где нужна одна строка
+func (e Eligibility) message() string {
+	messages := make([]string, len(e.Reasons))
+	for i, reason := range e.Reasons {
+		messages[i] = reason.Message
+	}
+	return "Client is not eligible to borrow: " + strings.Join(messages, "; ")
+}
+
+// Объяснение, может ли клиент брать книги
+func GetClientEligibility(w http.ResponseWriter, r *http.Request) {
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	eligibility, err := evaluateEligibility(db.DB, clientID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Ошибка проверки допуска клиента:", err)
+		http.Error(w, "Error checking eligibility", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(eligibility)
+}
+
+func GetEligibilityRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := loadEligibilityRules(db.DB)
+	if err != nil {
+		http.Error(w, "Error fetching eligibility rules", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(rules)
+}
+
+func UpdateEligibilityRules(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+
+	var rules EligibilityRules
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var errs []FieldError
+	if rules.MaxOutstandingFine.Valid && rules.MaxOutstandingFine.Decimal.IsNegative() {
+		errs = append(errs, FieldError{"max_outstanding_fine", "must not be negative"})
+	}
+	if rules.OverdueGraceDays < 0 {
+		errs = append(errs, FieldError{"overdue_grace_days", "must not be negative"})
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	_, err := db.DB.Exec(`
+        INSERT INTO eligibility_rules (id, max_outstanding_fine, block_on_overdue, overdue_grace_days)
+        VALUES (1, $1, $2, $3)
+        ON CONFLICT (id) DO UPDATE SET
+            max_outstanding_fine = EXCLUDED.max_outstanding_fine,
+            block_on_overdue = EXCLUDED.block_on_overdue,
+            overdue_grace_days = EXCLUDED.overdue_grace_days`,
+		rules.MaxOutstandingFine, rules.BlockOnOverdue, rules.OverdueGraceDays)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(rules)
+}
+
+const clientBlockColumns = `id, client_id, reason, COALESCE(expires_at::text, ''), COALESCE(created_by, ''), created_at::text,
+        COALESCE(lifted_at::text, ''), COALESCE(lifted_by, '')`
+
+func scanClientBlock(row rowScanner) (ClientBlock, error) {
+	var block ClientBlock
+	err := row.Scan(&block.ID, &block.ClientID, &block.Reason, &block.ExpiresAt, &block.CreatedBy, &block.CreatedAt, &block.LiftedAt, &block.LiftedBy)
+	return block, err
+}
+
+// Все блокировки клиента, включая снятые и истекшие
+func GetClientBlocks(w http.ResponseWriter, r *http.Request) {
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	blocks, err := loadClientBlocks(db.DB, clientID)
+	if err != nil {
+		http.Error(w, "Error fetching blocks", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(blocks)
+}
+
+func loadClientBlocks(q queryer, clientID int) ([]ClientBlock, error) {
+	rows, err := q.Query("SELECT "+clientBlockColumns+" FROM client_blocks WHERE client_id = $1 ORDER BY created_at DESC", clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocks []ClientBlock
+	for rows.Next() {
+		block, err := scanClientBlock(rows)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, rows.Err()
+}
+
+// Ручная блокировка: причина обязательна, срок (expires_at, YYYY-MM-DD) - нет
+func AddClientBlock(w http.ResponseWriter, r *http.Request) {
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	var block ClientBlock
+	if err := json.NewDecoder(r.Body).Decode(&block); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var errs []FieldError
+	block.Reason = strings.TrimSpace(block.Reason)
+	if block.Reason == "" {
+		errs = append(errs, FieldError{"reason", "is required"})
+	}
+	var expiresAt *time.Time
+	if block.ExpiresAt != "" {
+		t, err := time.Parse("2006-01-02", block.ExpiresAt)
+		if err != nil {
+			errs = append(errs, FieldError{"expires_at", "must be a date in YYYY-MM-DD format"})
+		} else if !t.After(time.Now()) {
+			errs = append(errs, FieldError{"expires_at", "must be in the future"})
+		}
+		expiresAt = &t
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	block, err = scanClientBlock(db.DB.QueryRow(`
+        INSERT INTO client_blocks (client_id, reason, expires_at, created_by)
+        VALUES ($1, $2, $3, NULLIF($4, ''))
+        RETURNING `+clientBlockColumns, clientID, block.Reason, expiresAt, currentUsername(r)))
+	if isForeignKeyViolation(err) {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Ошибка блокировки клиента:", err)
+		http.Error(w, "Error adding block", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(block)
+}
+
+// Снятие блокировки; запись остается в истории
+func LiftClientBlock(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clientID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+	blockID, err := strconv.Atoi(vars["blockId"])
+	if err != nil {
+		http.Error(w, "Invalid block ID", http.StatusBadRequest)
+		return
+	}
+
+	block, err := scanClientBlock(db.DB.QueryRow(`
+        UPDATE client_blocks SET lifted_at = NOW(), lifted_by = NULLIF($3, '')
+        WHERE id = $1 AND client_id = $2 AND lifted_at IS NULL
+        RETURNING `+clientBlockColumns, blockID, clientID, currentUsername(r)))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Active block not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error lifting block", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(block)
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"library-backend/db"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Ключ advisory-блокировки, чтобы начисление не выполнялось одновременно на нескольких репликах
+const fineAccrualLockKey = 29002
+
+// Сколько выдач пересчитывается в одной транзакции
+const fineAccrualBatchSize = 500
+
+// Запуск фонового начисления штрафов. Каждая выдача пересчитывается раз в день,
+// поэтому интервал может быть меньше суток: после перезапуска или смены даты
+// пересчет наверстывается на ближайшем проходе.
+func StartFineAccrualJob(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if n, err := accrueFines(false); err != nil {
+				log.Println("Ошибка начисления штрафов:", err)
+			} else if n > 0 {
+				log.Printf("Пересчитан штраф по %d выдачам", n)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Пересчет набежавшего штрафа по невозвращенным выдачам, еще не пересчитанным сегодня.
+// Обрабатываются просроченные выдачи и выдачи с ненулевым штрафом: после продления
+// или изменения календаря штраф может уменьшиться. С wait ожидает блокировку,
+// иначе пропускает проход, если его уже выполняет другая реплика.
+func accrueFines(wait bool) (int, error) {
+	processed := 0
+	for {
+		n, err := accrueFinesBatch(wait)
+		processed += n
+		if err != nil || n < fineAccrualBatchSize {
+			return processed, err
+		}
+	}
+}
+
+func accrueFinesBatch(wait bool) (int, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if wait {
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", fineAccrualLockKey); err != nil {
+			return 0, err
+		}
+	} else {
+		var locked bool
+		if err := tx.QueryRow("SELECT pg_try_advisory_xact_lock($1)", fineAccrualLockKey).Scan(&locked); err != nil {
+			return 0, err
+		}
+		if !locked {
+			return 0, nil
+		}
+	}
+
+	rows, err := tx.Query(`
+        SELECT id FROM journal
+        WHERE date_ret IS NULL AND (date_end < CURRENT_DATE OR fine_today > 0)
+          AND fine_accrued_on IS DISTINCT FROM CURRENT_DATE
+        ORDER BY id
+        LIMIT $1
+        FOR UPDATE`, fineAccrualBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var journalIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		journalIDs = append(journalIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, journalID := range journalIDs {
+		if err := accrueLoanFine(tx, journalID); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(journalIDs), nil
+}
+
+// Штраф по выдаче на сегодня по ее правилам штрафов
+func accrueLoanFine(tx *sql.Tx, journalID int) error {
+	policy, item, err := loanFineItem(tx, journalID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE journal SET fine_today = $2, fine_accrued_on = CURRENT_DATE WHERE id = $1",
+		journalID, policy.Assess(item).Amount)
+	return err
+}
+
+// Пересчет штрафов по всем невозвращенным выдачам немедленно, например после
+// изменения правил штрафов или календаря
+func RecomputeFines(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+
+	_, err := db.DB.Exec("UPDATE journal SET fine_accrued_on = NULL WHERE date_ret IS NULL")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	processed, err := accrueFines(true)
+	if err != nil {
+		log.Println("Ошибка начисления штрафов:", err)
+		http.Error(w, "Error recomputing fines", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Processed int `json:"processed"`
+	}{Processed: processed})
+}
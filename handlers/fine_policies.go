@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"library-backend/db"
+	"library-backend/fines"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+// Денежные суммы (decimal.Decimal) в ответах API - числа JSON, как и до перехода
+// на decimal, а не строки. Во входных данных принимаются и числа, и строки.
+func init() {
+	decimal.MarshalJSONWithoutQuotes = true
+}
+
+const finePolicyColumns = "id, name, grace_days, max_per_item, max_per_loan, cap_at_replacement_cost, is_default"
+
+func scanFinePolicy(row rowScanner) (fines.Policy, error) {
+	var policy fines.Policy
+	err := row.Scan(&policy.ID, &policy.Name, &policy.GraceDays, &policy.MaxPerItem, &policy.MaxPerLoan,
+		&policy.CapAtReplacementCost, &policy.IsDefault)
+	return policy, err
+}
+
+func loadFinePolicyRates(q queryer, policy *fines.Policy) error {
+	policy.Rates = []fines.RateStep{}
+	rows, err := q.Query("SELECT from_day, rate FROM fine_policy_rates WHERE policy_id = $1 ORDER BY from_day", policy.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var step fines.RateStep
+		if err := rows.Scan(&step.FromDay, &step.Rate); err != nil {
+			return err
+		}
+		policy.Rates = append(policy.Rates, step)
+	}
+	return rows.Err()
+}
+
+func loadFinePolicy(q queryer, id int) (fines.Policy, error) {
+	policy, err := scanFinePolicy(q.QueryRow("SELECT "+finePolicyColumns+" FROM fine_policies WHERE id = $1", id))
+	if err != nil {
+		return policy, err
+	}
+	return policy, loadFinePolicyRates(q, &policy)
+}
+
+// Данные выдачи для расчета штрафа и действующие для нее правила: правила типа книги
+// или правила по умолчанию. Просрочка считается в рабочих днях от текущего срока до возврата
+// или до сегодня; штраф, начисленный при продлении просроченной выдачи, учитывается в пределах.
+func loanFineItem(q queryer, journalID int) (fines.Policy, fines.Item, error) {
+	var item fines.Item
+	var policyID int
+	err := q.QueryRow(`
+        SELECT library_open_days(j.date_end::date, COALESCE(j.date_ret::date, CURRENT_DATE)),
+               bt.fine, pc.fine_multiplier,
+               COALESCE(bt.fine_policy_id, (SELECT id FROM fine_policies WHERE is_default)),
+               (SELECT l.price
+                FROM purchase_order_lines l
+                JOIN purchase_orders o ON l.order_id = o.id
+                WHERE l.book_id = j.book_id AND l.received > 0
+                ORDER BY o.id DESC
+                LIMIT 1),
+               COALESCE((SELECT SUM(f.amount) FROM fine_ledger f WHERE f.journal_id = j.id AND f.entry_type = 'lost_item'), 0),
+               COALESCE((SELECT SUM(f.amount) FROM fine_ledger f WHERE f.journal_id = j.id AND f.entry_type = 'overdue'), 0)
+        FROM journal j
+        JOIN books b ON j.book_id = b.id
+        JOIN book_types bt ON b.type_id = bt.id
+        JOIN clients c ON j.client_id = c.id
+        JOIN patron_categories pc ON c.category_id = pc.id
+        WHERE j.id = $1`, journalID).Scan(&item.DaysLate, &item.BaseRate, &item.Multiplier, &policyID,
+		&item.ReplacementCost, &item.OtherCharges, &item.PriorOverdue)
+	if err != nil {
+		return fines.Policy{}, item, err
+	}
+
+	policy, err := loadFinePolicy(q, policyID)
+	return policy, item, err
+}
+
+func GetFinePolicies(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.DB.Query("SELECT " + finePolicyColumns + " FROM fine_policies ORDER BY name")
+	if err != nil {
+		http.Error(w, "Error fetching fine policies", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	policies := []fines.Policy{}
+	for rows.Next() {
+		policy, err := scanFinePolicy(rows)
+		if err != nil {
+			http.Error(w, "Error scanning fine policies", http.StatusInternalServerError)
+			return
+		}
+		policies = append(policies, policy)
+	}
+	rows.Close()
+
+	for i := range policies {
+		if err := loadFinePolicyRates(db.DB, &policies[i]); err != nil {
+			http.Error(w, "Error fetching fine policies", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(policies)
+}
+
+func GetFinePolicy(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid policy ID", http.StatusBadRequest)
+		return
+	}
+
+	policy, err := loadFinePolicy(db.DB, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Fine policy not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error fetching fine policy", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(policy)
+}
+
+func decodeFinePolicy(w http.ResponseWriter, r *http.Request) (fines.Policy, bool) {
+	var policy fines.Policy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return policy, false
+	}
+	var errs []FieldError
+	if policy.Name == "" {
+		errs = append(errs, FieldError{"name", "is required"})
+	}
+	if err := policy.Validate(); err != nil {
+		errs = append(errs, FieldError{"policy", err.Error()})
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return policy, false
+	}
+	return policy, true
+}
+
+// Сохранение правил вместе со ставками; ставки заменяются целиком
+func saveFinePolicy(w http.ResponseWriter, policy fines.Policy, id int) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if policy.IsDefault {
+		if _, err := tx.Exec("UPDATE fine_policies SET is_default = FALSE WHERE is_default AND id <> $1", id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if id == 0 {
+		err = tx.QueryRow(`
+            INSERT INTO fine_policies (name, grace_days, max_per_item, max_per_loan, cap_at_replacement_cost, is_default)
+            VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+			policy.Name, policy.GraceDays, policy.MaxPerItem, policy.MaxPerLoan, policy.CapAtReplacementCost, policy.IsDefault).Scan(&policy.ID)
+	} else {
+		policy.ID = id
+		var res sql.Result
+		res, err = tx.Exec(`
+            UPDATE fine_policies
+            SET name = $1, grace_days = $2, max_per_item = $3, max_per_loan = $4, cap_at_replacement_cost = $5, is_default = $6
+            WHERE id = $7`,
+			policy.Name, policy.GraceDays, policy.MaxPerItem, policy.MaxPerLoan, policy.CapAtReplacementCost, policy.IsDefault, id)
+		if err == nil {
+			if n, _ := res.RowsAffected(); n == 0 {
+				http.Error(w, "Fine policy not found", http.StatusNotFound)
+				return
+			}
+		}
+	}
+	if isUniqueViolation(err) {
+		writeValidationErrors(w, []FieldError{{"name", "is already used"}})
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM fine_policy_rates WHERE policy_id = $1", policy.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, step := range policy.Rates {
+		_, err := tx.Exec("INSERT INTO fine_policy_rates (policy_id, from_day, rate) VALUES ($1, $2, $3)",
+			policy.ID, step.FromDay, step.Rate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Без правил по умолчанию штраф не посчитать
+	var hasDefault bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM fine_policies WHERE is_default)").Scan(&hasDefault); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !hasDefault {
+		writeValidationErrors(w, []FieldError{{"is_default", "another policy must be made default first"}})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if policy.Rates == nil {
+		policy.Rates = []fines.RateStep{}
+	}
+	if id == 0 {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(policy)
+}
+
+func AddFinePolicy(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+	policy, ok := decodeFinePolicy(w, r)
+	if !ok {
+		return
+	}
+	saveFinePolicy(w, policy, 0)
+}
+
+func UpdateFinePolicy(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid policy ID", http.StatusBadRequest)
+		return
+	}
+	policy, ok := decodeFinePolicy(w, r)
+	if !ok {
+		return
+	}
+	saveFinePolicy(w, policy, id)
+}
+
+// Удаление правил; правила по умолчанию и назначенные типам книг удалить нельзя
+func DeleteFinePolicy(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid policy ID", http.StatusBadRequest)
+		return
+	}
+
+	var isDefault bool
+	err = db.DB.QueryRow("SELECT is_default FROM fine_policies WHERE id = $1", id).Scan(&isDefault)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Fine policy not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if isDefault {
+		http.Error(w, "Cannot delete the default fine policy", http.StatusConflict)
+		return
+	}
+
+	_, err = db.DB.Exec("DELETE FROM fine_policies WHERE id = $1", id)
+	if isForeignKeyViolation(err) {
+		http.Error(w, "Fine policy is assigned to book types", http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Println("Ошибка удаления правил штрафов:", err)
+		http.Error(w, "Error deleting fine policy", http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Fine policy deleted successfully"))
+}
+
+// Расчет штрафа по правилам без записи: ?days= просрочки, ?rate= ставка типа книги,
+// ?multiplier= категории, ?replacement_cost= стоимость возмещения
+func PreviewFinePolicy(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid policy ID", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	item := fines.Item{Multiplier: decimal.NewFromInt(1)}
+	if item.DaysLate, err = strconv.Atoi(query.Get("days")); err != nil {
+		http.Error(w, "Invalid days", http.StatusBadRequest)
+		return
+	}
+	for name, target := range map[string]*decimal.Decimal{"rate": &item.BaseRate, "multiplier": &item.Multiplier} {
+		if value := query.Get(name); value != "" {
+			if *target, err = decimal.NewFromString(value); err != nil {
+				http.Error(w, "Invalid "+name, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if value := query.Get("replacement_cost"); value != "" {
+		if item.ReplacementCost.Decimal, err = decimal.NewFromString(value); err != nil {
+			http.Error(w, "Invalid replacement_cost", http.StatusBadRequest)
+			return
+		}
+		item.ReplacementCost.Valid = true
+	}
+
+	policy, err := loadFinePolicy(db.DB, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Fine policy not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error fetching fine policy", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(policy.Assess(item))
+}
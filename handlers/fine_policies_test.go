@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"library-backend/fines"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestMoneyEncodesAsJSONNumber(t *testing.T) {
+	policy := fines.Policy{
+		Name:       "standard",
+		MaxPerItem: decimal.NewNullDecimal(decimal.RequireFromString("150.00")),
+		Rates:      []fines.RateStep{{FromDay: 1, Rate: decimal.RequireFromString("2.50")}},
+	}
+	bookType := BookType{Type: "book", Fine: decimal.RequireFromString("12.50"), MaxDays: 14}
+
+	tests := []struct {
+		name  string
+		value any
+		field string
+		want  float64
+	}{
+		{name: "book type fine", value: bookType, field: "fine", want: 12.5},
+		{name: "nullable policy limit", value: policy, field: "max_per_item", want: 150},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded map[string]any
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := decoded[tt.field].(float64)
+			if !ok {
+				t.Fatalf("%s = %#v (%s), want JSON number", tt.field, decoded[tt.field], data)
+			}
+			if got != tt.want {
+				t.Errorf("%s = %v, want %v", tt.field, got, tt.want)
+			}
+		})
+	}
+
+	// Пустой предел остается null
+	data, err := json.Marshal(fines.Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := decoded["max_per_item"]; !ok || v != nil {
+		t.Errorf("max_per_item = %#v, want null", v)
+	}
+}
@@ -0,0 +1,577 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"library-backend/db"
+	"library-backend/fines"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/shopspring/decimal"
+)
+
+// Проводка по штрафам клиента: начисление (amount > 0) или поступление (amount < 0)
+type FineEntry struct {
+	ID         int             `json:"id"`
+	ClientID   int             `json:"client_id"`
+	JournalID  int             `json:"journal_id,omitempty"`
+	BookName   string          `json:"book_name,omitempty"`
+	EntryType  string          `json:"entry_type"`
+	Amount     decimal.Decimal `json:"amount"`
+	Reason     string          `json:"reason"`
+	ApprovedBy string          `json:"approved_by,omitempty"`
+	ReceiptID  int             `json:"receipt_id,omitempty"`
+	CreatedBy  string          `json:"created_by"`
+	CreatedAt  string          `json:"created_at"`
+}
+
+type FineReceipt struct {
+	ID            int             `json:"id"`
+	ClientID      int             `json:"client_id"`
+	ClientName    string          `json:"client_name"`
+	Amount        decimal.Decimal `json:"amount"`
+	Method        string          `json:"method"`
+	BalanceBefore decimal.Decimal `json:"balance_before"`
+	BalanceAfter  decimal.Decimal `json:"balance_after"`
+	ReceivedBy    string          `json:"received_by"`
+	CreatedAt     string          `json:"created_at"`
+}
+
+type ClientFines struct {
+	ClientID int `json:"client_id"`
+	// Неоплаченный остаток по всем проводкам
+	Balance decimal.Decimal `json:"balance"`
+	Entries []FineEntry     `json:"entries"`
+}
+
+const fineEntryColumns = `f.id, f.client_id, COALESCE(f.journal_id, 0), COALESCE(b.name, ''), f.entry_type, f.amount,
+        f.reason, COALESCE(f.approved_by, ''), COALESCE(f.receipt_id, 0), COALESCE(f.created_by, ''), f.created_at::text`
+
+const fineEntryFrom = `fine_ledger f
+        LEFT JOIN journal j ON f.journal_id = j.id
+        LEFT JOIN books b ON j.book_id = b.id`
+
+func scanFineEntry(row rowScanner) (FineEntry, error) {
+	var e FineEntry
+	err := row.Scan(&e.ID, &e.ClientID, &e.JournalID, &e.BookName, &e.EntryType, &e.Amount,
+		&e.Reason, &e.ApprovedBy, &e.ReceiptID, &e.CreatedBy, &e.CreatedAt)
+	return e, err
+}
+
+// Все проводки клиента в хронологическом порядке
+func loadFineEntries(q queryer, clientID int) ([]FineEntry, error) {
+	rows, err := q.Query("SELECT "+fineEntryColumns+" FROM "+fineEntryFrom+" WHERE f.client_id = $1 ORDER BY f.created_at, f.id", clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []FineEntry{}
+	for rows.Next() {
+		entry, err := scanFineEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Запись проводки. Знак суммы задается типом проводки.
+func addFineEntry(tx *sql.Tx, entry FineEntry) (int, error) {
+	var id int
+	err := tx.QueryRow(`
+        INSERT INTO fine_ledger (client_id, journal_id, entry_type, amount, reason, approved_by, receipt_id, created_by)
+        VALUES ($1, NULLIF($2, 0), $3, $4, $5, NULLIF($6, ''), NULLIF($7, 0), NULLIF($8, ''))
+        RETURNING id`,
+		entry.ClientID, entry.JournalID, entry.EntryType, entry.Amount, entry.Reason, entry.ApprovedBy, entry.ReceiptID, entry.CreatedBy).Scan(&id)
+	return id, err
+}
+
+// Клиент блокируется, чтобы баланс не изменился между проверкой и записью проводки
+func lockClientBalance(tx *sql.Tx, clientID int) (decimal.Decimal, error) {
+	var exists bool
+	err := tx.QueryRow("SELECT TRUE FROM clients WHERE id = $1 FOR UPDATE", clientID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return decimal.Zero, rejectRequest(http.StatusNotFound, "Client not found")
+	} else if err != nil {
+		return decimal.Zero, err
+	}
+	return clientOutstandingFine(tx, clientID)
+}
+
+// Баланс и проводки по штрафам клиента
+func GetClientFines(w http.ResponseWriter, r *http.Request) {
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	fines := ClientFines{ClientID: clientID}
+	if fines.Entries, err = loadFineEntries(db.DB, clientID); err != nil {
+		http.Error(w, "Error fetching fines", http.StatusInternalServerError)
+		return
+	}
+	for _, entry := range fines.Entries {
+		fines.Balance = fines.Balance.Add(entry.Amount)
+	}
+
+	json.NewEncoder(w).Encode(fines)
+}
+
+type PaymentRequest struct {
+	Amount decimal.Decimal `json:"amount"`
+	Method string          `json:"method"`
+}
+
+// Прием оплаты штрафа. Оплата меньше остатка проводится как частичная,
+// переплата не принимается. Возвращает квитанцию.
+func AddFinePayment(w http.ResponseWriter, r *http.Request) {
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	var req PaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if req.Method == "" {
+		req.Method = "cash"
+	}
+	var errs []FieldError
+	if msg := validMoney(req.Amount); msg != "" {
+		errs = append(errs, FieldError{Field: "amount", Message: msg})
+	}
+	if req.Method != "cash" && req.Method != "card" {
+		errs = append(errs, FieldError{Field: "method", Message: "must be cash or card"})
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	receipt, err := recordPayment(tx, clientID, req, currentUsername(r))
+	if err != nil {
+		writeRequestError(w, err, "Error recording payment")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(receipt)
+}
+
+func recordPayment(tx *sql.Tx, clientID int, req PaymentRequest, receivedBy string) (FineReceipt, error) {
+	balance, err := lockClientBalance(tx, clientID)
+	if err != nil {
+		return FineReceipt{}, err
+	}
+	if !balance.IsPositive() {
+		return FineReceipt{}, rejectRequest(http.StatusConflict, "Client has no outstanding fines")
+	}
+	if req.Amount.GreaterThan(balance) {
+		return FineReceipt{}, rejectRequest(http.StatusConflict, "Payment exceeds outstanding balance of "+balance.StringFixed(2))
+	}
+
+	var receiptID int
+	err = tx.QueryRow(`
+        INSERT INTO fine_receipts (client_id, amount, method, balance_before, balance_after, received_by)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+        RETURNING id`, clientID, req.Amount, req.Method, balance, balance.Sub(req.Amount), receivedBy).Scan(&receiptID)
+	if err != nil {
+		return FineReceipt{}, err
+	}
+
+	entryType := "payment"
+	if req.Amount.LessThan(balance) {
+		entryType = "partial_payment"
+	}
+	_, err = addFineEntry(tx, FineEntry{
+		ClientID:  clientID,
+		EntryType: entryType,
+		Amount:    req.Amount.Neg(),
+		ReceiptID: receiptID,
+		CreatedBy: receivedBy,
+	})
+	if err != nil {
+		return FineReceipt{}, err
+	}
+
+	return loadReceipt(tx, receiptID)
+}
+
+type WaiverRequest struct {
+	Amount decimal.Decimal `json:"amount"`
+	Reason string          `json:"reason"`
+	// Выдача, штраф по которой списывается; необязательно
+	JournalID int `json:"journal_id"`
+}
+
+// Списание штрафа. Утверждает администратор, причина обязательна.
+func WaiveFine(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	var req WaiverRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	var errs []FieldError
+	if msg := validMoney(req.Amount); msg != "" {
+		errs = append(errs, FieldError{Field: "amount", Message: msg})
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		errs = append(errs, FieldError{Field: "reason", Message: "is required"})
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	balance, err := lockClientBalance(tx, clientID)
+	if err != nil {
+		writeRequestError(w, err, "Error waiving fine")
+		return
+	}
+	if req.Amount.GreaterThan(balance) {
+		http.Error(w, "Waiver exceeds outstanding balance of "+balance.StringFixed(2), http.StatusConflict)
+		return
+	}
+
+	if req.JournalID != 0 {
+		var loanClientID int
+		err := tx.QueryRow("SELECT client_id FROM journal WHERE id = $1", req.JournalID).Scan(&loanClientID)
+		if err == sql.ErrNoRows || (err == nil && loanClientID != clientID) {
+			http.Error(w, "Journal entry not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	username := currentUsername(r)
+	entryID, err := addFineEntry(tx, FineEntry{
+		ClientID:   clientID,
+		JournalID:  req.JournalID,
+		EntryType:  "waiver",
+		Amount:     req.Amount.Neg(),
+		Reason:     strings.TrimSpace(req.Reason),
+		ApprovedBy: username,
+		CreatedBy:  username,
+	})
+	if err != nil {
+		log.Println("Ошибка списания штрафа:", err)
+		http.Error(w, "Error waiving fine", http.StatusInternalServerError)
+		return
+	}
+
+	entry, err := scanFineEntry(tx.QueryRow("SELECT "+fineEntryColumns+" FROM "+fineEntryFrom+" WHERE f.id = $1", entryID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Списан штраф %s клиента %d, утвердил %s", req.Amount.StringFixed(2), clientID, username)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// Утеря книги: выдача закрывается без возврата экземпляра, клиенту начисляются
+// стоимость возмещения (по умолчанию цена последней поставки) и штраф за просрочку
+// на сегодня по правилам штрафов.
+func MarkLoanLost(w http.ResponseWriter, r *http.Request) {
+	journalID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid journal ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Amount decimal.NullDecimal `json:"amount"`
+		Reason string              `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if req.Amount.Valid {
+		if msg := validMoney(req.Amount.Decimal); msg != "" {
+			writeValidationErrors(w, []FieldError{{Field: "amount", Message: msg}})
+			return
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var clientID int
+	var returned bool
+	err = tx.QueryRow("SELECT client_id, date_ret IS NOT NULL FROM journal WHERE id = $1 FOR UPDATE", journalID).Scan(&clientID, &returned)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Journal entry not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if returned {
+		http.Error(w, "Book is already returned", http.StatusConflict)
+		return
+	}
+
+	policy, item, err := loanFineItem(tx, journalID)
+	if err != nil {
+		log.Println("Ошибка расчета штрафа:", err)
+		http.Error(w, "Error calculating fine", http.StatusInternalServerError)
+		return
+	}
+
+	lostAmount := req.Amount.Decimal
+	if !req.Amount.Valid {
+		if !item.ReplacementCost.Valid || !item.ReplacementCost.Decimal.IsPositive() {
+			writeValidationErrors(w, []FieldError{{Field: "amount", Message: "book has no purchase price, amount is required"}})
+			return
+		}
+		lostAmount = item.ReplacementCost.Decimal
+	}
+	item.OtherCharges = item.OtherCharges.Add(lostAmount)
+	overdue := policy.Assess(item)
+
+	// Выдача закрывается, экземпляр на полку не возвращается
+	_, err = tx.Exec("UPDATE journal SET date_ret = NOW(), lost = TRUE, fine_today = $2 WHERE id = $1", journalID, overdue.Amount)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	username := currentUsername(r)
+	entries := []FineEntry{{
+		ClientID:  clientID,
+		JournalID: journalID,
+		EntryType: "lost_item",
+		Amount:    lostAmount,
+		Reason:    strings.TrimSpace(req.Reason),
+		CreatedBy: username,
+	}}
+	if overdue.Amount.IsPositive() {
+		entries = append(entries, overdueFineEntry(clientID, journalID, overdue, username))
+	}
+
+	charged := []FineEntry{}
+	for _, entry := range entries {
+		if !entry.Amount.IsPositive() {
+			continue
+		}
+		entryID, err := addFineEntry(tx, entry)
+		if err != nil {
+			log.Println("Ошибка начисления за утерю:", err)
+			http.Error(w, "Error recording lost item", http.StatusInternalServerError)
+			return
+		}
+		entry, err = scanFineEntry(tx.QueryRow("SELECT "+fineEntryColumns+" FROM "+fineEntryFrom+" WHERE f.id = $1", entryID))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		charged = append(charged, entry)
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(charged)
+}
+
+// Начисление штрафа за просрочку по расчету правил
+func overdueFineEntry(clientID, journalID int, assessment fines.Assessment, createdBy string) FineEntry {
+	reason := fmt.Sprintf("Просрочка %d дн.", assessment.DaysLate)
+	if assessment.CappedBy != fines.CapNone {
+		reason += ", ограничено: " + assessment.CappedBy
+	}
+	return FineEntry{
+		ClientID:  clientID,
+		JournalID: journalID,
+		EntryType: "overdue",
+		Amount:    assessment.Amount,
+		Reason:    reason,
+		CreatedBy: createdBy,
+	}
+}
+
+// Денежная сумма из запроса: положительная, не точнее копейки
+func validMoney(amount decimal.Decimal) string {
+	if !amount.IsPositive() {
+		return "must be positive"
+	}
+	if !amount.Equal(amount.Round(2)) {
+		return "must have at most 2 decimal places"
+	}
+	return ""
+}
+
+const receiptColumns = `r.id, r.client_id, TRIM(c.last_name || ' ' || c.first_name || ' ' || COALESCE(c.father_name, '')),
+        r.amount, r.method, r.balance_before, r.balance_after, COALESCE(r.received_by, ''), r.created_at::text`
+
+func scanReceipt(row rowScanner) (FineReceipt, error) {
+	var receipt FineReceipt
+	err := row.Scan(&receipt.ID, &receipt.ClientID, &receipt.ClientName,
+		&receipt.Amount, &receipt.Method, &receipt.BalanceBefore, &receipt.BalanceAfter, &receipt.ReceivedBy, &receipt.CreatedAt)
+	return receipt, err
+}
+
+func loadReceipt(q queryer, receiptID int) (FineReceipt, error) {
+	return scanReceipt(q.QueryRow("SELECT "+receiptColumns+" FROM fine_receipts r JOIN clients c ON r.client_id = c.id WHERE r.id = $1", receiptID))
+}
+
+func loadClientReceipts(q queryer, clientID int) ([]FineReceipt, error) {
+	rows, err := q.Query(`
+        SELECT `+receiptColumns+`
+        FROM fine_receipts r
+        JOIN clients c ON r.client_id = c.id
+        WHERE r.client_id = $1
+        ORDER BY r.created_at, r.id`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	receipts := []FineReceipt{}
+	for rows.Next() {
+		receipt, err := scanReceipt(rows)
+		if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, receipt)
+	}
+	return receipts, rows.Err()
+}
+
+// Квитанции клиента
+func GetClientReceipts(w http.ResponseWriter, r *http.Request) {
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	receipts, err := loadClientReceipts(db.DB, clientID)
+	if err != nil {
+		http.Error(w, "Error fetching receipts", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(receipts)
+}
+
+// Квитанция об оплате; с ?format=pdf - для печати на ленте 80 мм
+func GetReceipt(w http.ResponseWriter, r *http.Request) {
+	receiptID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid receipt ID", http.StatusBadRequest)
+		return
+	}
+
+	receipt, err := loadReceipt(db.DB, receiptID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Receipt not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error fetching receipt", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") != "pdf" {
+		json.NewEncoder(w).Encode(receipt)
+		return
+	}
+
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{UnitStr: "mm", Size: gofpdf.SizeType{Wd: 80, Ht: 100}})
+	pdf.SetMargins(4, 4, 4)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddPage()
+	fontFamily, translate := labelFont(pdf)
+
+	methods := map[string]string{"cash": "наличные", "card": "карта"}
+	lines := []struct {
+		label, value string
+	}{
+		{"Клиент", receipt.ClientName},
+		{"Дата", receipt.CreatedAt[:min(len(receipt.CreatedAt), 19)]},
+		{"Способ оплаты", methods[receipt.Method]},
+		{"Долг до оплаты", receipt.BalanceBefore.StringFixed(2)},
+		{"Оплачено", receipt.Amount.StringFixed(2)},
+		{"Остаток", receipt.BalanceAfter.StringFixed(2)},
+		{"Принял", receipt.ReceivedBy},
+	}
+
+	pdf.SetFont(fontFamily, "", 11)
+	pdf.CellFormat(72, 7, translate(fmt.Sprintf("Квитанция № %d", receipt.ID)), "", 1, "C", false, 0, "")
+	pdf.CellFormat(72, 5, translate("Оплата штрафа"), "", 1, "C", false, 0, "")
+	pdf.Ln(3)
+	pdf.SetFont(fontFamily, "", 9)
+	for _, line := range lines {
+		pdf.CellFormat(30, 6, translate(line.label), "", 0, "L", false, 0, "")
+		pdf.CellFormat(42, 6, translate(line.value), "", 1, "R", false, 0, "")
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		log.Println("Ошибка формирования PDF:", err)
+		http.Error(w, "Error generating PDF", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="receipt-`+strconv.Itoa(receipt.ID)+`.pdf"`)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"library-backend/db"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Сколько дней отложенный экземпляр ждет читателя; если срок выпадает на нерабочий
+// день, он переносится на ближайший рабочий
+const holdPickupDays = 7
+
+type Hold struct {
+	ID        int    `json:"id"`
+	ClientID  int    `json:"client_id"`
+	BookID    int    `json:"book_id"`
+	BookName  string `json:"book_name"`
+	Status    string `json:"status"`
+	Position  int    `json:"position"`
+	CreatedAt string `json:"created_at"`
+	ClosedAt  string `json:"closed_at"`
+	// Заполнены, когда экземпляр отложен для читателя
+	ReadyAt        string `json:"ready_at"`
+	PickupDeadline string `json:"pickup_deadline"`
+}
+
+// Колонки резерва с названием книги и местом в очереди среди действующих резервов
+const holdColumns = `h.id, h.client_id, h.book_id, b.name, h.status,
+        CASE WHEN h.status IN ('waiting', 'ready') THEN (
+            SELECT COUNT(*) FROM holds q
+            WHERE q.book_id = h.book_id AND q.status IN ('waiting', 'ready')
+              AND (q.created_at, q.id) <= (h.created_at, h.id)
+        ) ELSE 0 END,
+        h.created_at::text, COALESCE(h.closed_at::text, ''),
+        COALESCE(h.ready_at::text, ''), COALESCE(h.pickup_deadline::text, '')`
+
+func scanHold(row rowScanner) (Hold, error) {
+	var hold Hold
+	err := row.Scan(&hold.ID, &hold.ClientID, &hold.BookID, &hold.BookName, &hold.Status, &hold.Position, &hold.CreatedAt, &hold.ClosedAt, &hold.ReadyAt, &hold.PickupDeadline)
+	return hold, err
+}
+
+// Резервы клиента; activeOnly оставляет только ожидающие и готовые к выдаче
+func loadClientHolds(q queryer, clientID int, activeOnly bool) ([]Hold, error) {
+	rows, err := q.Query(`
+        SELECT `+holdColumns+`
+        FROM holds h
+        JOIN books b ON h.book_id = b.id
+        WHERE h.client_id = $1 AND (NOT $2 OR h.status IN ('waiting', 'ready'))
+        ORDER BY h.created_at DESC`, clientID, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []Hold{}
+	for rows.Next() {
+		hold, err := scanHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, hold)
+	}
+	return holds, rows.Err()
+}
+
+// Резервирование издания клиентом. Клиент должен иметь право брать книги.
+// Если свободный экземпляр есть на полке, он сразу откладывается по очереди.
+func placeHold(tx *sql.Tx, clientID, bookID int) (Hold, error) {
+	eligibility, err := evaluateEligibility(tx, clientID)
+	if err == sql.ErrNoRows {
+		return Hold{}, rejectRequest(http.StatusNotFound, "Client not found")
+	} else if err != nil {
+		return Hold{}, err
+	}
+	if !eligibility.Eligible {
+		return Hold{}, rejectRequest(http.StatusForbidden, eligibility.message())
+	}
+
+	var circulating bool
+	err = tx.QueryRow(`
+        SELECT COALESCE(b.circulating, bt.circulating)
+        FROM books b
+        JOIN book_types bt ON b.type_id = bt.id
+        WHERE b.id = $1`, bookID).Scan(&circulating)
+	if err == sql.ErrNoRows {
+		return Hold{}, rejectRequest(http.StatusNotFound, "Book not found")
+	} else if err != nil {
+		return Hold{}, err
+	}
+	if !circulating {
+		return Hold{}, rejectRequest(http.StatusBadRequest, "Book is for reading room use only")
+	}
+
+	var onLoan bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM journal WHERE client_id = $1 AND book_id = $2 AND date_ret IS NULL)", clientID, bookID).Scan(&onLoan)
+	if err != nil {
+		return Hold{}, err
+	}
+	if onLoan {
+		return Hold{}, rejectRequest(http.StatusConflict, "Client already has this book on loan")
+	}
+
+	var holdID int
+	err = tx.QueryRow("INSERT INTO holds (client_id, book_id) VALUES ($1, $2) RETURNING id", clientID, bookID).Scan(&holdID)
+	if isUniqueViolation(err) {
+		return Hold{}, rejectRequest(http.StatusConflict, "Client already has a hold on this book")
+	} else if err != nil {
+		return Hold{}, err
+	}
+
+	if _, err := trapHolds(tx, bookID); err != nil {
+		return Hold{}, err
+	}
+
+	return loadHold(tx, holdID)
+}
+
+func loadHold(q queryer, holdID int) (Hold, error) {
+	return scanHold(q.QueryRow("SELECT "+holdColumns+" FROM holds h JOIN books b ON h.book_id = b.id WHERE h.id = $1", holdID))
+}
+
+// Отмена действующего резерва. clientID > 0 ограничивает отмену резервами этого клиента.
+// Отложенный под резерв экземпляр переходит следующему в очереди.
+func cancelHold(tx *sql.Tx, holdID, clientID int) error {
+	var bookID int
+	err := tx.QueryRow(`
+        UPDATE holds SET status = 'cancelled', closed_at = NOW()
+        WHERE id = $1 AND ($2 = 0 OR client_id = $2) AND status IN ('waiting', 'ready')
+        RETURNING book_id`, holdID, clientID).Scan(&bookID)
+	if err == sql.ErrNoRows {
+		return rejectRequest(http.StatusNotFound, "Active hold not found")
+	} else if err != nil {
+		return err
+	}
+	_, err = trapHolds(tx, bookID)
+	return err
+}
+
+// Экземпляры издания на полке, не отложенные под резервы. Строка книги блокируется,
+// чтобы выдача, возврат и очередь не разошлись.
+func freeCopies(tx *sql.Tx, bookID int) (int, error) {
+	var free int
+	err := tx.QueryRow(`
+        SELECT b.cnt - (SELECT COUNT(*) FROM holds WHERE book_id = b.id AND status = 'ready')
+        FROM books b
+        WHERE b.id = $1
+        FOR UPDATE OF b`, bookID).Scan(&free)
+	return free, err
+}
+
+// Откладывание свободных экземпляров для первых в очереди. Возвращает резервы,
+// ставшие готовыми к выдаче.
+func trapHolds(tx *sql.Tx, bookID int) ([]Hold, error) {
+	free, err := freeCopies(tx, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	trapped := []Hold{}
+	for ; free > 0; free-- {
+		var holdID int
+		err := tx.QueryRow(`
+            UPDATE holds SET status = 'ready', ready_at = NOW(), pickup_deadline = library_next_open_day(CURRENT_DATE + $2::int)
+            WHERE id = (
+                SELECT id FROM holds
+                WHERE book_id = $1 AND status = 'waiting'
+                ORDER BY created_at, id
+                LIMIT 1
+            )
+            RETURNING id`, bookID, holdPickupDays).Scan(&holdID)
+		if err == sql.ErrNoRows {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		hold, err := loadHold(tx, holdID)
+		if err != nil {
+			return nil, err
+		}
+		trapped = append(trapped, hold)
+	}
+	return trapped, nil
+}
+
+// Запуск фоновой обработки резервов: истечение срока хранения и раздача свободных экземпляров
+func StartHoldJob(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := processHolds(); err != nil {
+				log.Println("Ошибка обработки резервов:", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Резервы, не забранные до крайнего срока, истекают. Затем свободные экземпляры
+// всех изданий с очередью откладываются следующим читателям - в том числе
+// после отмен при объединении и обезличивании клиентов.
+func processHolds() error {
+	rows, err := db.DB.Query(`
+        SELECT DISTINCT book_id FROM holds
+        WHERE status = 'waiting' OR (status = 'ready' AND pickup_deadline < CURRENT_DATE)`)
+	if err != nil {
+		return err
+	}
+	var bookIDs []int
+	for rows.Next() {
+		var bookID int
+		if err := rows.Scan(&bookID); err != nil {
+			rows.Close()
+			return err
+		}
+		bookIDs = append(bookIDs, bookID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, bookID := range bookIDs {
+		if err := processBookHolds(bookID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func processBookHolds(bookID int) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Книга блокируется до изменения резервов
+	if _, err := freeCopies(tx, bookID); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`
+        UPDATE holds SET status = 'expired', closed_at = NOW()
+        WHERE book_id = $1 AND status = 'ready' AND pickup_deadline < CURRENT_DATE`, bookID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Истек срок хранения %d резервов на книгу %d", n, bookID)
+	}
+
+	if _, err := trapHolds(tx, bookID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Очередь резервов на издание
+func GetBookHolds(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+        SELECT `+holdColumns+`
+        FROM holds h
+        JOIN books b ON h.book_id = b.id
+        WHERE h.book_id = $1 AND h.status IN ('waiting', 'ready')
+        ORDER BY h.created_at, h.id`, bookID)
+	if err != nil {
+		http.Error(w, "Error fetching holds", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	holds := []Hold{}
+	for rows.Next() {
+		hold, err := scanHold(rows)
+		if err != nil {
+			http.Error(w, "Error fetching holds", http.StatusInternalServerError)
+			return
+		}
+		holds = append(holds, hold)
+	}
+
+	json.NewEncoder(w).Encode(holds)
+}
+
+// Полка отложенных книг: готовые к выдаче резервы по возрастанию крайнего срока
+func GetReadyHolds(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.DB.Query(`
+        SELECT ` + holdColumns + `
+        FROM holds h
+        JOIN books b ON h.book_id = b.id
+        WHERE h.status = 'ready'
+        ORDER BY h.pickup_deadline, h.id`)
+	if err != nil {
+		http.Error(w, "Error fetching holds", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	holds := []Hold{}
+	for rows.Next() {
+		hold, err := scanHold(rows)
+		if err != nil {
+			http.Error(w, "Error fetching holds", http.StatusInternalServerError)
+			return
+		}
+		holds = append(holds, hold)
+	}
+
+	json.NewEncoder(w).Encode(holds)
+}
+
+// Все резервы клиента, с ?active=true - только действующие
+func GetClientHolds(w http.ResponseWriter, r *http.Request) {
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	holds, err := loadClientHolds(db.DB, clientID, r.URL.Query().Get("active") == "true")
+	if err != nil {
+		http.Error(w, "Error fetching holds", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(holds)
+}
+
+// Резервирование издания библиотекарем по id клиента или номеру билета
+func AddHold(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ClientID   int    `json:"client_id"`
+		CardNumber string `json:"card_number"`
+		BookID     int    `json:"book_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.BookID == 0 {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if req.ClientID == 0 && req.CardNumber != "" {
+		req.ClientID, err = clientIDByCard(req.CardNumber)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Card not found or inactive", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Error checking card", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	hold, err := placeHold(tx, req.ClientID, req.BookID)
+	if err != nil {
+		writeRequestError(w, err, "Error placing hold")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hold)
+}
+
+func CancelHold(w http.ResponseWriter, r *http.Request) {
+	holdID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid hold ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := cancelHold(tx, holdID, 0); err != nil {
+		writeRequestError(w, err, "Error cancelling hold")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Hold cancelled successfully"))
+}
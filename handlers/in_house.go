@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"library-backend/db"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Использование книги в читальном зале. Книга должна быть сдана в тот же день
+type InHouseUse struct {
+	ID         int    `json:"id"`
+	BookID     int    `json:"book_id"`
+	ClientID   *int   `json:"client_id"`
+	UsedAt     string `json:"used_at"`
+	ReturnedAt string `json:"returned_at"`
+}
+
+// Выдача в читальный зал разрешена для любых книг, в том числе невыдаваемых на дом.
+// Читатель необязателен: справочные издания часто берут с полки без записи.
+func CheckoutInHouse(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		BookID   int  `json:"book_id"`
+		ClientID *int `json:"client_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var availableCnt int
+	err = tx.QueryRow("SELECT cnt FROM books WHERE id = $1 FOR UPDATE", request.BookID).Scan(&availableCnt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if availableCnt <= 0 {
+		http.Error(w, "No books available for issuing", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE books SET cnt = cnt - 1 WHERE id = $1", request.BookID); err != nil {
+		log.Println("Ошибка обновления количества книг:", err)
+		http.Error(w, "Error updating book count", http.StatusInternalServerError)
+		return
+	}
+
+	var use InHouseUse
+	err = tx.QueryRow("INSERT INTO in_house_uses (book_id, client_id) VALUES ($1, $2) RETURNING id, book_id, client_id, used_at",
+		request.BookID, request.ClientID).Scan(&use.ID, &use.BookID, &use.ClientID, &use.UsedAt)
+	if err != nil {
+		log.Println("Ошибка записи использования в читальном зале:", err)
+		http.Error(w, "Error recording in-house use", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(use)
+}
+
+func ReturnInHouse(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		UseID int `json:"use_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var bookID int
+	var usedAt time.Time
+	var returnedAt sql.NullTime
+	err = tx.QueryRow("SELECT book_id, used_at, returned_at FROM in_house_uses WHERE id = $1 FOR UPDATE", request.UseID).Scan(&bookID, &usedAt, &returnedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "In-house use not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if returnedAt.Valid {
+		http.Error(w, "Book is already returned", http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	if _, err := tx.Exec("UPDATE in_house_uses SET returned_at = $1 WHERE id = $2", now, request.UseID); err != nil {
+		http.Error(w, "Error updating return date", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE books SET cnt = cnt + 1 WHERE id = $1", bookID); err != nil {
+		log.Println("Ошибка увеличения количества книг:", err)
+		http.Error(w, "Error updating book count", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Возврат не в день выдачи фиксируется, но не блокируется
+	json.NewEncoder(w).Encode(struct {
+		SameDay bool `json:"same_day"`
+	}{
+		SameDay: usedAt.Format("2006-01-02") == now.Format("2006-01-02"),
+	})
+}
+
+// Книги, не сданные из читального зала (в том числе забытые с прошлых дней)
+func GetOpenInHouseUses(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.DB.Query(`
+        SELECT id, book_id, client_id, used_at
+        FROM in_house_uses
+        WHERE returned_at IS NULL
+        ORDER BY used_at`)
+	if err != nil {
+		http.Error(w, "Error fetching in-house uses", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var uses []InHouseUse
+	for rows.Next() {
+		var use InHouseUse
+		if err := rows.Scan(&use.ID, &use.BookID, &use.ClientID, &use.UsedAt); err != nil {
+			http.Error(w, "Error scanning in-house uses", http.StatusInternalServerError)
+			return
+		}
+		uses = append(uses, use)
+	}
+
+	json.NewEncoder(w).Encode(uses)
+}
+
+type InHouseStat struct {
+	BookID     int    `json:"book_id"`
+	BookName   string `json:"name"`
+	Uses       int    `json:"uses"`
+	NotSameDay int    `json:"not_same_day"`
+}
+
+// Статистика использования в читальном зале за период (?from=YYYY-MM-DD&to=YYYY-MM-DD)
+func GetInHouseUseStats(w http.ResponseWriter, r *http.Request) {
+	from, to := time.Now().AddDate(0, -1, 0), time.Now()
+	var err error
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.Parse("2006-01-02", v); err != nil {
+			http.Error(w, "Invalid date format. Use YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.Parse("2006-01-02", v); err != nil {
+			http.Error(w, "Invalid date format. Use YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+
+	query := `
+        SELECT
+            b.id,
+            b.name,
+            COUNT(*) AS uses,
+            COUNT(*) FILTER (WHERE u.returned_at IS NULL OR u.returned_at::date <> u.used_at::date) AS not_same_day
+        FROM in_house_uses u
+        JOIN books b ON u.book_id = b.id
+        WHERE u.used_at::date BETWEEN $1::date AND $2::date
+        GROUP BY b.id, b.name
+        ORDER BY uses DESC;
+    `
+
+	rows, err := db.DB.Query(query, from, to)
+	if err != nil {
+		http.Error(w, "Error fetching in-house use stats", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var stats []InHouseStat
+	total := 0
+	for rows.Next() {
+		var stat InHouseStat
+		if err := rows.Scan(&stat.BookID, &stat.BookName, &stat.Uses, &stat.NotSameDay); err != nil {
+			http.Error(w, "Error scanning in-house use stats", http.StatusInternalServerError)
+			return
+		}
+		total += stat.Uses
+		stats = append(stats, stat)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		From  string        `json:"from"`
+		To    string        `json:"to"`
+		Total int           `json:"total"`
+		Books []InHouseStat `json:"books"`
+	}{
+		From:  from.Format("2006-01-02"),
+		To:    to.Format("2006-01-02"),
+		Total: total,
+		Books: stats,
+	})
+}
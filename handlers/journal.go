@@ -3,25 +3,29 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"library-backend/db"
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/shopspring/decimal"
 )
 
 type JournalEntry struct {
-	ID         int    `json:"id"`
-	BookID     int    `json:"book_id"`
-	ClientID   int    `json:"client_id"`
-	DateBeg    string `json:"date_beg"`
-	DateEnd    string `json:"date_end"`
-	DateRet    string `json:"date_ret"`
-	Fine       int    `json:"fine_today"`
-	FinePerDay int    `json:"fine_per_day"`
+	ID         int             `json:"id"`
+	BookID     int             `json:"book_id"`
+	ClientID   int             `json:"client_id"`
+	DateBeg    string          `json:"date_beg"`
+	DateEnd    string          `json:"date_end"`
+	DateRet    string          `json:"date_ret"`
+	Fine       decimal.Decimal `json:"fine_today"`
+	FinePerDay decimal.Decimal `json:"fine_per_day"`
+	Renewals   int             `json:"renewal_count"`
 }
 
 func GetJournalEntries(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.DB.Query(`SELECT j.id, j.book_id, j.client_id, j.date_beg, j.date_end, j.date_ret, j.fine_today, bt.fine AS fine_per_day 
+	rows, err := db.DB.Query(`SELECT j.id, j.book_id, j.client_id, j.date_beg, j.date_end, j.date_ret, COALESCE(j.fine_today, 0), bt.fine AS fine_per_day, j.renewal_count
 								FROM journal j
 								JOIN books b on j.book_id = b.id
 								JOIN book_types bt ON b.type_id = bt.id`)
@@ -35,7 +39,7 @@ func GetJournalEntries(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var entry JournalEntry
 		var dateRet sql.NullString
-		err := rows.Scan(&entry.ID, &entry.BookID, &entry.ClientID, &entry.DateBeg, &entry.DateEnd, &dateRet, &entry.Fine, &entry.FinePerDay)
+		err := rows.Scan(&entry.ID, &entry.BookID, &entry.ClientID, &entry.DateBeg, &entry.DateEnd, &dateRet, &entry.Fine, &entry.FinePerDay, &entry.Renewals)
 		if err != nil {
 			http.Error(w, "Error scanning journal entry", http.StatusInternalServerError)
 			return
@@ -54,9 +58,12 @@ func GetJournalEntries(w http.ResponseWriter, r *http.Request) {
 }
 
 type IssueRequest struct {
-	BookID   int    `json:"book_id"`
-	ClientID int    `json:"client_id"`
-	DateEnd  string `json:"date_end"`
+	BookID   int `json:"book_id"`
+	ClientID int `json:"client_id"`
+	// Номер читательского билета, можно передать вместо client_id
+	CardNumber string `json:"card_number"`
+	// Срок возврата; если не указан, берется максимальный для типа книги и категории клиента
+	DateEnd string `json:"date_end"`
 }
 
 func IssueBook(w http.ResponseWriter, r *http.Request) {
@@ -68,10 +75,49 @@ func IssueBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dateEnd, err := time.Parse("2006-01-02", request.DateEnd)
+	if request.ClientID == 0 && request.CardNumber != "" {
+		request.ClientID, err = clientIDByCard(request.CardNumber)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Card not found or inactive", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Ошибка поиска читательского билета:", err)
+			http.Error(w, "Error checking card", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var dateEnd time.Time
+	if request.DateEnd != "" {
+		dateEnd, err = time.Parse("2006-01-02", request.DateEnd)
+		if err != nil {
+			log.Println("Ошибка преобразования даты:", err)
+			http.Error(w, "Invalid date format. Use YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Членство, штрафы, просрочки и ручные блокировки
+	eligibility, err := evaluateEligibility(db.DB, request.ClientID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Ошибка проверки допуска клиента:", err)
+		http.Error(w, "Error checking eligibility", http.StatusInternalServerError)
+		return
+	}
+	if !eligibility.Eligible {
+		log.Println("Клиенту отказано в выдаче:", eligibility.message())
+		http.Error(w, eligibility.message(), http.StatusForbidden)
+		return
+	}
+
+	// Лимиты и сроки зависят от категории читателя
+	category, err := clientCategory(db.DB, request.ClientID)
 	if err != nil {
-		log.Println("Ошибка преобразования даты:", err)
-		http.Error(w, "Invalid date format. Use YYYY-MM-DD", http.StatusBadRequest)
+		log.Println("Ошибка получения категории клиента:", err)
+		http.Error(w, "Error checking client category", http.StatusInternalServerError)
 		return
 	}
 
@@ -83,29 +129,110 @@ func IssueBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if booksOnHand >= 10 {
-		log.Println("Клиент уже имеет 10 книг на руках")
-		http.Error(w, "Client cannot have more than 10 books", http.StatusBadRequest)
+	if booksOnHand >= category.MaxLoans {
+		log.Printf("Клиент уже имеет %d книг на руках", booksOnHand)
+		http.Error(w, fmt.Sprintf("Client cannot have more than %d books", category.MaxLoans), http.StatusBadRequest)
 		return
 	}
 
-	// Проверка доступного количества книг
-	var availableCnt int
-	err = db.DB.QueryRow("SELECT cnt FROM books WHERE id = $1", request.BookID).Scan(&availableCnt)
+	var typeID, typeDays int
+	var circulating bool
+	err = db.DB.QueryRow(`
+        SELECT COALESCE(b.circulating, bt.circulating), b.type_id, bt.day_count
+        FROM books b
+        JOIN book_types bt ON b.type_id = bt.id
+        WHERE b.id = $1`, request.BookID).Scan(&circulating, &typeID, &typeDays)
 	if err != nil {
 		log.Println("Ошибка получения количества книг:", err)
 		http.Error(w, "Book not found", http.StatusNotFound)
 		return
 	}
 
-	if availableCnt <= 0 {
+	if !circulating {
+		log.Println("Книга выдается только в читальный зал")
+		http.Error(w, "Book is for reading room use only", http.StatusBadRequest)
+		return
+	}
+
+	if maxOfType, ok := category.typeLimit(typeID); ok {
+		var ofTypeOnHand int
+		err = db.DB.QueryRow(`
+            SELECT COUNT(*)
+            FROM journal j
+            JOIN books b ON j.book_id = b.id
+            WHERE j.client_id = $1 AND j.date_ret IS NULL AND b.type_id = $2`, request.ClientID, typeID).Scan(&ofTypeOnHand)
+		if err != nil {
+			log.Println("Ошибка получения количества книг у клиента:", err)
+			http.Error(w, "Error checking client's books", http.StatusInternalServerError)
+			return
+		}
+		if ofTypeOnHand >= maxOfType {
+			http.Error(w, fmt.Sprintf("Client cannot have more than %d books of this type", maxOfType), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Срок выдачи типа книги с учетом множителя категории; 0 - без ограничения
+	if typeDays > 0 {
+		maxDays := category.loanDays(typeDays)
+		now := time.Now()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		maxEnd := today.AddDate(0, 0, maxDays)
+		if dateEnd.IsZero() {
+			dateEnd = maxEnd
+		} else if dateEnd.After(maxEnd) {
+			http.Error(w, fmt.Sprintf("Loan period for this client cannot exceed %d days", maxDays), http.StatusBadRequest)
+			return
+		}
+	}
+	if dateEnd.IsZero() {
+		http.Error(w, "date_end is required for this book type", http.StatusBadRequest)
+		return
+	}
+
+	// Срок, выпавший на выходной или праздник, переносится на ближайший рабочий день
+	err = db.DB.QueryRow("SELECT library_next_open_day($1::date)", dateEnd.Format("2006-01-02")).Scan(&dateEnd)
+	if err != nil {
+		log.Println("Ошибка проверки календаря:", err)
+		http.Error(w, "Error checking library calendar", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Свободные экземпляры - те, что не отложены под чужие резервы.
+	// Отложенный для этого клиента экземпляр выдается ему.
+	free, err := freeCopies(tx, request.BookID)
+	if err != nil {
+		log.Println("Ошибка получения количества книг:", err)
+		http.Error(w, "Error checking book count", http.StatusInternalServerError)
+		return
+	}
+	var holdID int
+	var holdStatus string
+	err = tx.QueryRow(`
+        SELECT id, status FROM holds
+        WHERE client_id = $1 AND book_id = $2 AND status IN ('waiting', 'ready')
+        FOR UPDATE`, request.ClientID, request.BookID).Scan(&holdID, &holdStatus)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("Ошибка проверки резерва:", err)
+		http.Error(w, "Error checking holds", http.StatusInternalServerError)
+		return
+	}
+
+	if free <= 0 && holdStatus != "ready" {
 		log.Println("Книг нет в наличии")
 		http.Error(w, "No books available for issuing", http.StatusBadRequest)
 		return
 	}
 
 	// Уменьшаем количество книг
-	_, err = db.DB.Exec("UPDATE books SET cnt = cnt - 1 WHERE id = $1", request.BookID)
+	_, err = tx.Exec("UPDATE books SET cnt = cnt - 1 WHERE id = $1", request.BookID)
 	if err != nil {
 		log.Println("Ошибка обновления количества книг:", err)
 		http.Error(w, "Error updating book count", http.StatusInternalServerError)
@@ -113,14 +240,30 @@ func IssueBook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Добавляем запись в журнал
-	query := "INSERT INTO journal (book_id, client_id, date_beg, date_end) VALUES ($1, $2, $3, $4)"
-	_, err = db.DB.Exec(query, request.BookID, request.ClientID, time.Now(), dateEnd)
+	var journalID int
+	query := "INSERT INTO journal (book_id, client_id, date_beg, date_end) VALUES ($1, $2, $3, $4) RETURNING id"
+	err = tx.QueryRow(query, request.BookID, request.ClientID, time.Now(), dateEnd).Scan(&journalID)
 	if err != nil {
 		log.Println("Ошибка добавления записи в журнал:", err)
 		http.Error(w, "Error issuing book", http.StatusInternalServerError)
 		return
 	}
 
+	// Резерв клиента на это издание исполнен выдачей
+	if holdID != 0 {
+		_, err = tx.Exec("UPDATE holds SET status = 'fulfilled', closed_at = NOW(), journal_id = $2 WHERE id = $1", holdID, journalID)
+		if err != nil {
+			log.Println("Ошибка исполнения резерва:", err)
+			http.Error(w, "Error issuing book", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte("Book issued successfully"))
 }
@@ -137,50 +280,83 @@ func ReturnBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Получаем информацию о книге и дате возврата
-	var dateEnd, dateRet sql.NullTime
-	var finePerDay, bookID int
-	query := `
-        SELECT j.date_end, bt.fine, j.book_id
-        FROM journal j
-        JOIN books b ON j.book_id = b.id
-        JOIN book_types bt ON b.type_id = bt.id
-        WHERE j.id = $1`
-	err = db.DB.QueryRow(query, request.JournalID).Scan(&dateEnd, &finePerDay, &bookID)
+	tx, err := db.DB.Begin()
 	if err != nil {
-		http.Error(w, "Journal entry not found", http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	dateRet.Time = time.Now()
+	// Получаем информацию о книге
+	var bookID, clientID int
+	var returned bool
+	query := "SELECT book_id, client_id, date_ret IS NOT NULL FROM journal WHERE id = $1 FOR UPDATE"
+	err = tx.QueryRow(query, request.JournalID).Scan(&bookID, &clientID, &returned)
+	if err != nil {
+		http.Error(w, "Journal entry not found", http.StatusNotFound)
+		return
+	}
+	if returned {
+		http.Error(w, "Book is already returned", http.StatusConflict)
+		return
+	}
 
-	// Рассчитываем штраф
-	var totalFine int
-	if dateEnd.Valid && dateRet.Time.After(dateEnd.Time) {
-		daysLate := int(dateRet.Time.Sub(dateEnd.Time).Hours() / 24)
-		totalFine = finePerDay * daysLate
+	// Рассчитываем штраф по правилам штрафов типа книги с учетом категории читателя
+	policy, item, err := loanFineItem(tx, request.JournalID)
+	if err != nil {
+		log.Println("Ошибка расчета штрафа:", err)
+		http.Error(w, "Error calculating fine", http.StatusInternalServerError)
+		return
 	}
+	assessment := policy.Assess(item)
 
 	// Обновляем запись о возврате и фиксируем штраф
-	_, err = db.DB.Exec("UPDATE journal SET date_ret = $1, fine_today = $2 WHERE id = $3", dateRet.Time, totalFine, request.JournalID)
+	_, err = tx.Exec("UPDATE journal SET date_ret = $1, fine_today = $2 WHERE id = $3", time.Now(), assessment.Amount, request.JournalID)
 	if err != nil {
 		http.Error(w, "Error updating return date", http.StatusInternalServerError)
 		return
 	}
 
+	// Штраф начисляется в учет штрафов клиента
+	if assessment.Amount.IsPositive() {
+		_, err = addFineEntry(tx, overdueFineEntry(clientID, request.JournalID, assessment, currentUsername(r)))
+		if err != nil {
+			log.Println("Ошибка начисления штрафа:", err)
+			http.Error(w, "Error recording fine", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Увеличиваем количество книг
-	_, err = db.DB.Exec("UPDATE books SET cnt = cnt + 1 WHERE id = $1", bookID)
+	_, err = tx.Exec("UPDATE books SET cnt = cnt + 1 WHERE id = $1", bookID)
 	if err != nil {
 		log.Println("Ошибка увеличения количества книг:", err)
 		http.Error(w, "Error updating book count", http.StatusInternalServerError)
 		return
 	}
 
-	// Возвращаем итоговый штраф
+	// Вернувшийся экземпляр откладывается для первого в очереди
+	trapped, err := trapHolds(tx, bookID)
+	if err != nil {
+		log.Println("Ошибка обработки очереди резервов:", err)
+		http.Error(w, "Error processing holds", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Возвращаем итоговый штраф и резерв, под который нужно отложить книгу
 	response := struct {
-		Fine int `json:"fine"`
+		Fine decimal.Decimal `json:"fine"`
+		Hold *Hold           `json:"hold,omitempty"`
 	}{
-		Fine: totalFine,
+		Fine: assessment.Amount,
+	}
+	if len(trapped) > 0 {
+		response.Hold = &trapped[0]
 	}
 	json.NewEncoder(w).Encode(response)
 }
@@ -196,9 +372,9 @@ func GetFine(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Логика для получения штрафа за просрочку
-	var fine int
+	var fine decimal.Decimal
 	query := `
-        SELECT fine_today
+        SELECT COALESCE(fine_today, 0)
         FROM journal j
         JOIN books b ON j.book_id = b.id
         JOIN book_types bt ON b.type_id = bt.id
@@ -211,6 +387,6 @@ func GetFine(w http.ResponseWriter, r *http.Request) {
 
 	// Отправляем штраф обратно на фронтенд
 	json.NewEncoder(w).Encode(struct {
-		Fine int `json:"fine"`
+		Fine decimal.Decimal `json:"fine"`
 	}{Fine: fine})
 }
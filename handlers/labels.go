@@ -0,0 +1,388 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"library-backend/db"
+	"library-backend/utils"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/gorilla/mux"
+	"github.com/jung-kurt/gofpdf"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Размер этикетки и раскладка листа A4, все размеры в миллиметрах
+type LabelSize struct {
+	Width      float64 `json:"width_mm"`
+	Height     float64 `json:"height_mm"`
+	MarginLeft float64 `json:"margin_left_mm"`
+	MarginTop  float64 `json:"margin_top_mm"`
+	GapX       float64 `json:"gap_x_mm"`
+	GapY       float64 `json:"gap_y_mm"`
+	Columns    int     `json:"columns"`
+	Rows       int     `json:"rows"`
+}
+
+// Типовые листы самоклеящихся этикеток
+var labelSizes = map[string]LabelSize{
+	"a4-3x8":  {Width: 70, Height: 37, MarginLeft: 0, MarginTop: 0.5, Columns: 3, Rows: 8},
+	"a4-2x7":  {Width: 99.1, Height: 38.1, MarginLeft: 4.65, MarginTop: 15.15, GapX: 2.5, Columns: 2, Rows: 7},
+	"a4-4x10": {Width: 48.5, Height: 25.4, MarginLeft: 8, MarginTop: 21.5, Columns: 4, Rows: 10},
+	"spine":   {Width: 25, Height: 38, MarginLeft: 10, MarginTop: 10, GapX: 2, GapY: 2, Columns: 7, Rows: 7},
+}
+
+const (
+	a4Width  = 210.0
+	a4Height = 297.0
+	// Поле вокруг содержимого этикетки
+	labelPadding = 2.0
+)
+
+type labelData struct {
+	BookID    int
+	Barcode   string
+	Title     string
+	ShelfMark string
+}
+
+// Штрихкод экземпляра - ID книги, дополненный нулями до 8 знаков.
+// Инвентаризация распознает такой код как внутренний ID.
+func bookBarcode(bookID int) string {
+	return fmt.Sprintf("%08d", bookID)
+}
+
+func shortTitle(name string, maxRunes int) string {
+	runes := []rune(strings.TrimSpace(name))
+	if len(runes) <= maxRunes {
+		return string(runes)
+	}
+	return strings.TrimSpace(string(runes[:maxRunes-3])) + "..."
+}
+
+// Шифр хранения: полка книги, а если она не указана - первый код классификатора
+func loadLabelData(bookID int) (labelData, error) {
+	data := labelData{BookID: bookID, Barcode: bookBarcode(bookID)}
+	query := `
+        SELECT b.name, COALESCE(b.shelf, (
+            SELECT c.code FROM book_classes bc JOIN classes c ON c.id = bc.class_id
+            WHERE bc.book_id = b.id ORDER BY c.scheme, c.code LIMIT 1
+        ), '')
+        FROM books b
+        WHERE b.id = $1`
+	err := db.DB.QueryRow(query, bookID).Scan(&data.Title, &data.ShelfMark)
+	data.Title = shortTitle(data.Title, 32)
+	return data, err
+}
+
+func resolveLabelSize(name string, width, height float64) (LabelSize, bool) {
+	if width > 0 && height > 0 {
+		if width > a4Width-20 || height > a4Height-20 {
+			return LabelSize{}, false
+		}
+		// Произвольный размер раскладывается сеткой с полями 10 мм
+		return LabelSize{
+			Width:      width,
+			Height:     height,
+			MarginLeft: 10,
+			MarginTop:  10,
+			Columns:    int((a4Width - 20) / width),
+			Rows:       int((a4Height - 20) / height),
+		}, true
+	}
+	if name == "" {
+		name = "a4-3x8"
+	}
+	size, ok := labelSizes[name]
+	return size, ok
+}
+
+func GetLabelSizes(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(labelSizes)
+}
+
+func encodeBarcode(content string) (barcode.Barcode, error) {
+	return code128.Encode(content)
+}
+
+// Предел числа этикеток в одном запросе: PDF собирается в памяти
+const maxLabelsPerRequest = 1000
+
+// Лист этикеток в PDF для выбранных книг
+func GetLabelSheet(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		BookIDs []int   `json:"book_ids"`
+		Copies  int     `json:"copies"`
+		Size    string  `json:"size"`
+		Width   float64 `json:"width_mm"`
+		Height  float64 `json:"height_mm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.BookIDs) == 0 {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if request.Copies <= 0 {
+		request.Copies = 1
+	}
+	if request.Copies > maxLabelsPerRequest {
+		writeValidationErrors(w, []FieldError{{"copies", fmt.Sprintf("must not exceed %d", maxLabelsPerRequest)}})
+		return
+	}
+	if len(request.BookIDs)*request.Copies > maxLabelsPerRequest {
+		writeValidationErrors(w, []FieldError{{"book_ids", fmt.Sprintf("at most %d labels per request", maxLabelsPerRequest)}})
+		return
+	}
+
+	size, ok := resolveLabelSize(request.Size, request.Width, request.Height)
+	if !ok {
+		http.Error(w, "Unknown label size", http.StatusBadRequest)
+		return
+	}
+
+	var labels []labelData
+	for _, bookID := range request.BookIDs {
+		data, err := loadLabelData(bookID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Book not found: "+strconv.Itoa(bookID), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Error fetching book", http.StatusInternalServerError)
+			return
+		}
+		for i := 0; i < request.Copies; i++ {
+			labels = append(labels, data)
+		}
+	}
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetAutoPageBreak(false, 0)
+	fontFamily, translate := labelFont(pdf)
+
+	perPage := size.Columns * size.Rows
+	for i, label := range labels {
+		if i%perPage == 0 {
+			pdf.AddPage()
+		}
+		cell := i % perPage
+		x := size.MarginLeft + float64(cell%size.Columns)*(size.Width+size.GapX)
+		y := size.MarginTop + float64(cell/size.Columns)*(size.Height+size.GapY)
+
+		if err := drawPDFLabel(pdf, fontFamily, translate, label, x, y, size.Width, size.Height); err != nil {
+			log.Println("Ошибка построения штрихкода:", err)
+			http.Error(w, "Error generating barcode", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		log.Println("Ошибка формирования PDF:", err)
+		http.Error(w, "Error generating PDF", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="labels.pdf"`)
+	w.Write(buf.Bytes())
+}
+
+// Шрифт с кириллицей задается через LABEL_FONT_PATH (TTF).
+// Без него используется встроенный Helvetica, а текст транслитерируется.
+func labelFont(pdf *gofpdf.Fpdf) (string, func(string) string) {
+	if path := os.Getenv("LABEL_FONT_PATH"); path != "" {
+		pdf.AddUTF8Font("label", "", path)
+		if pdf.Err() {
+			log.Println("Ошибка загрузки шрифта этикеток:", pdf.Error())
+			pdf.ClearError()
+		} else {
+			return "label", func(s string) string { return s }
+		}
+	}
+	return "Helvetica", utils.Transliterate
+}
+
+func drawPDFLabel(pdf *gofpdf.Fpdf, fontFamily string, translate func(string) string, label labelData, x, y, width, height float64) error {
+	bc, err := encodeBarcode(label.Barcode)
+	if err != nil {
+		return err
+	}
+
+	innerWidth := width - 2*labelPadding
+	fontSize := min(8, height/5)
+	lineHeight := fontSize * 0.3528 * 1.2
+
+	// Название сверху
+	pdf.SetFont(fontFamily, "", fontSize)
+	pdf.SetXY(x+labelPadding, y+labelPadding)
+	pdf.CellFormat(innerWidth, lineHeight, translate(label.Title), "", 0, "L", false, 0, "")
+
+	// Штрихкод посередине
+	barTop := y + labelPadding + lineHeight + 0.5
+	barHeight := height - 2*labelPadding - 3*lineHeight - 1
+	modules := bc.Bounds().Dx()
+	moduleWidth := innerWidth / float64(modules)
+	pdf.SetFillColor(0, 0, 0)
+	for m := 0; m < modules; m++ {
+		if isBar(bc, m) {
+			pdf.Rect(x+labelPadding+float64(m)*moduleWidth, barTop, moduleWidth, barHeight, "F")
+		}
+	}
+
+	// Код под штрихкодом и шифр хранения внизу
+	pdf.SetXY(x+labelPadding, barTop+barHeight)
+	pdf.CellFormat(innerWidth, lineHeight, label.Barcode, "", 0, "C", false, 0, "")
+	// Для подключенного TTF загружено только обычное начертание
+	style := ""
+	if fontFamily == "Helvetica" {
+		style = "B"
+	}
+	pdf.SetFont(fontFamily, style, fontSize)
+	pdf.SetXY(x+labelPadding, barTop+barHeight+lineHeight)
+	pdf.CellFormat(innerWidth, lineHeight, translate(label.ShelfMark), "", 0, "R", false, 0, "")
+
+	return nil
+}
+
+func isBar(bc barcode.Barcode, module int) bool {
+	r, _, _, _ := bc.At(bc.Bounds().Min.X+module, bc.Bounds().Min.Y).RGBA()
+	return r == 0
+}
+
+// Одна этикетка в SVG или PNG (?format=svg|png&size=...)
+func GetBookLabel(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+
+	size, ok := resolveLabelSize(r.URL.Query().Get("size"), 0, 0)
+	if !ok {
+		http.Error(w, "Unknown label size", http.StatusBadRequest)
+		return
+	}
+
+	label, err := loadLabelData(bookID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error fetching book", http.StatusInternalServerError)
+		return
+	}
+
+	bc, err := encodeBarcode(label.Barcode)
+	if err != nil {
+		log.Println("Ошибка построения штрихкода:", err)
+		http.Error(w, "Error generating barcode", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(renderSVGLabel(bc, label, size))
+	case "png":
+		img := renderPNGLabel(bc, label, size)
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, img)
+	default:
+		http.Error(w, "Unknown format. Use svg or png", http.StatusBadRequest)
+	}
+}
+
+func renderSVGLabel(bc barcode.Barcode, label labelData, size LabelSize) []byte {
+	var b bytes.Buffer
+	innerWidth := size.Width - 2*labelPadding
+	fontSize := min(3, size.Height/8)
+	barTop := labelPadding + fontSize + 0.5
+	barHeight := size.Height - 2*labelPadding - 3*fontSize - 1
+	modules := bc.Bounds().Dx()
+	moduleWidth := innerWidth / float64(modules)
+
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%gmm" height="%gmm" viewBox="0 0 %g %g">`,
+		size.Width, size.Height, size.Width, size.Height)
+	fmt.Fprintf(&b, `<rect width="%g" height="%g" fill="white"/>`, size.Width, size.Height)
+	fmt.Fprintf(&b, `<g font-family="sans-serif" font-size="%g">`, fontSize)
+	fmt.Fprintf(&b, `<text x="%g" y="%g">%s</text>`, labelPadding, labelPadding+fontSize, html.EscapeString(label.Title))
+	for m := 0; m < modules; m++ {
+		if isBar(bc, m) {
+			fmt.Fprintf(&b, `<rect x="%.3f" y="%g" width="%.3f" height="%g"/>`,
+				labelPadding+float64(m)*moduleWidth, barTop, moduleWidth, barHeight)
+		}
+	}
+	fmt.Fprintf(&b, `<text x="%g" y="%g" text-anchor="middle">%s</text>`,
+		size.Width/2, barTop+barHeight+fontSize, label.Barcode)
+	fmt.Fprintf(&b, `<text x="%g" y="%g" text-anchor="end" font-weight="bold">%s</text>`,
+		size.Width-labelPadding, barTop+barHeight+2*fontSize+0.5, html.EscapeString(label.ShelfMark))
+	b.WriteString(`</g></svg>`)
+	return b.Bytes()
+}
+
+// PNG рисуется при 12 точках на мм (~300 dpi). Встроенный растровый шрифт
+// поддерживает только ASCII, поэтому текст транслитерируется.
+func renderPNGLabel(bc barcode.Barcode, label labelData, size LabelSize) image.Image {
+	const dotsPerMM = 12
+	width, height := int(size.Width*dotsPerMM), int(size.Height*dotsPerMM)
+	padding := int(labelPadding * dotsPerMM)
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	face := basicfont.Face7x13
+	lineHeight := face.Metrics().Height.Ceil()
+	drawText := func(text string, y int, align string) {
+		d := &font.Drawer{Dst: img, Src: image.Black, Face: face}
+		textWidth := d.MeasureString(text).Ceil()
+		x := padding
+		switch align {
+		case "center":
+			x = (width - textWidth) / 2
+		case "right":
+			x = width - padding - textWidth
+		}
+		d.Dot = fixed.P(x, y)
+		d.DrawString(text)
+	}
+
+	drawText(utils.Transliterate(label.Title), padding+lineHeight, "left")
+
+	barTop := padding + lineHeight + 4
+	barHeight := height - 2*padding - 3*lineHeight - 8
+	if scaled, err := barcode.Scale(bc, width-2*padding, barHeight); err == nil {
+		draw.Draw(img, image.Rect(padding, barTop, width-padding, barTop+barHeight), scaled, image.Point{}, draw.Src)
+	} else {
+		// Этикетка уже, чем штрихкод в исходном масштабе: рисуем как есть
+		draw.Draw(img, image.Rect(padding, barTop, width-padding, barTop+barHeight), bc, bc.Bounds().Min, draw.Src)
+	}
+
+	drawText(label.Barcode, barTop+barHeight+lineHeight, "center")
+	drawText(utils.Transliterate(label.ShelfMark), barTop+barHeight+2*lineHeight+2, "right")
+
+	// Рамка для обрезки
+	border := color.Gray{Y: 200}
+	for x := 0; x < width; x++ {
+		img.Set(x, 0, border)
+		img.Set(x, height-1, border)
+	}
+	for y := 0; y < height; y++ {
+		img.Set(0, y, border)
+		img.Set(width-1, y, border)
+	}
+
+	return img
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"library-backend/db"
+	"library-backend/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+	"github.com/gorilla/mux"
+	"github.com/jung-kurt/gofpdf"
+)
+
+type LibraryCard struct {
+	ID         int    `json:"id"`
+	ClientID   int    `json:"client_id"`
+	CardNumber string `json:"card_number"`
+	Status     string `json:"status"`
+	IssuedAt   string `json:"issued_at"`
+	ReplacedAt string `json:"replaced_at"`
+}
+
+// Префикс номеров читательских билетов библиотеки
+const cardPrefix = "77"
+
+// Номер билета: префикс, 8 цифр из последовательности и контрольная цифра Луна
+func newCardNumber(tx *sql.Tx) (string, error) {
+	var seq int64
+	if err := tx.QueryRow("SELECT nextval('library_card_seq')").Scan(&seq); err != nil {
+		return "", err
+	}
+	body := cardPrefix + fmt.Sprintf("%08d", seq)
+	return body + string(utils.LuhnCheckDigit(body)), nil
+}
+
+func normalizeCardNumber(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// Поиск клиента по действующему билету. Номер с неверной контрольной цифрой
+// отклоняется без обращения к базе.
+func clientIDByCard(cardNumber string) (int, error) {
+	cardNumber = normalizeCardNumber(cardNumber)
+	if !utils.ValidLuhn(cardNumber) {
+		return 0, sql.ErrNoRows
+	}
+
+	var clientID int
+	err := db.DB.QueryRow("SELECT client_id FROM library_cards WHERE card_number = $1 AND status = 'active'", cardNumber).Scan(&clientID)
+	return clientID, err
+}
+
+const cardColumns = "id, client_id, card_number, status, issued_at, COALESCE(replaced_at::text, '')"
+
+func scanCard(row rowScanner) (LibraryCard, error) {
+	var card LibraryCard
+	err := row.Scan(&card.ID, &card.ClientID, &card.CardNumber, &card.Status, &card.IssuedAt, &card.ReplacedAt)
+	return card, err
+}
+
+func issueCard(w http.ResponseWriter, r *http.Request, replace bool) {
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Блокировка клиента не дает выпустить два билета параллельными запросами
+	err = tx.QueryRow("SELECT id FROM clients WHERE id = $1 FOR UPDATE", clientID).Scan(&clientID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res, err := tx.Exec("UPDATE library_cards SET status = 'replaced', replaced_at = NOW() WHERE client_id = $1 AND status = 'active'", clientID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	replaced, _ := res.RowsAffected()
+	if replaced > 0 && !replace {
+		http.Error(w, "Client already has an active card", http.StatusConflict)
+		return
+	}
+	if replaced == 0 && replace {
+		http.Error(w, "Client has no active card to replace", http.StatusConflict)
+		return
+	}
+
+	number, err := newCardNumber(tx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	card, err := scanCard(tx.QueryRow("INSERT INTO library_cards (client_id, card_number) VALUES ($1, $2) RETURNING "+cardColumns, clientID, number))
+	if err != nil {
+		log.Println("Ошибка выпуска читательского билета:", err)
+		http.Error(w, "Error issuing card", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(card)
+}
+
+func IssueLibraryCard(w http.ResponseWriter, r *http.Request) {
+	issueCard(w, r, false)
+}
+
+// Замена билета (утерян, испорчен): старый номер перестает действовать
+func ReplaceLibraryCard(w http.ResponseWriter, r *http.Request) {
+	issueCard(w, r, true)
+}
+
+// Все билеты клиента, действующий первым
+func GetClientCards(w http.ResponseWriter, r *http.Request) {
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	cards, err := loadClientCards(db.DB, clientID)
+	if err != nil {
+		http.Error(w, "Error fetching cards", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(cards)
+}
+
+func loadClientCards(q queryer, clientID int) ([]LibraryCard, error) {
+	rows, err := q.Query("SELECT "+cardColumns+" FROM library_cards WHERE client_id = $1 ORDER BY status = 'active' DESC, issued_at DESC", clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cards []LibraryCard
+	for rows.Next() {
+		card, err := scanCard(rows)
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, card)
+	}
+	return cards, rows.Err()
+}
+
+// Поиск клиента по номеру билета
+func GetClientByCard(w http.ResponseWriter, r *http.Request) {
+	number := normalizeCardNumber(mux.Vars(r)["number"])
+	if !utils.ValidLuhn(number) {
+		http.Error(w, "Invalid card number", http.StatusBadRequest)
+		return
+	}
+
+	clientID, err := clientIDByCard(number)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Card not found or inactive", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error fetching card", http.StatusInternalServerError)
+		return
+	}
+
+	client, err := scanClient(db.DB.QueryRow("SELECT "+clientColumns+" FROM clients WHERE id = $1", clientID), false)
+	if err != nil {
+		http.Error(w, "Error fetching client", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(client)
+}
+
+// Печатная форма действующего билета в формате CR80 (PDF) или QR-код (?format=qr)
+func PrintLibraryCard(w http.ResponseWriter, r *http.Request) {
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	var number, firstName, lastName, fatherName string
+	err = db.DB.QueryRow(`
+        SELECT lc.card_number, c.first_name, c.last_name, c.father_name
+        FROM library_cards lc
+        JOIN clients c ON c.id = lc.client_id
+        WHERE lc.client_id = $1 AND lc.status = 'active'`, clientID).Scan(&number, &firstName, &lastName, &fatherName)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Client has no active card", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error fetching card", http.StatusInternalServerError)
+		return
+	}
+
+	qrCode, err := qr.Encode(number, qr.M, qr.Auto)
+	if err == nil {
+		qrCode, err = barcode.Scale(qrCode, 240, 240)
+	}
+	if err != nil {
+		log.Println("Ошибка построения QR-кода:", err)
+		http.Error(w, "Error generating QR code", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "qr" {
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, qrCode)
+		return
+	}
+
+	bc, err := encodeBarcode(number)
+	if err != nil {
+		log.Println("Ошибка построения штрихкода:", err)
+		http.Error(w, "Error generating barcode", http.StatusInternalServerError)
+		return
+	}
+
+	// Размер банковской карты: 85.6 x 54 мм
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{UnitStr: "mm", Size: gofpdf.SizeType{Wd: 85.6, Ht: 54}})
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddPage()
+	fontFamily, translate := labelFont(pdf)
+
+	pdf.SetFont(fontFamily, "", 9)
+	pdf.SetXY(4, 4)
+	pdf.CellFormat(50, 5, translate("Читательский билет"), "", 0, "L", false, 0, "")
+	pdf.SetFont(fontFamily, "", 10)
+	pdf.SetXY(4, 11)
+	pdf.CellFormat(50, 5, translate(lastName), "", 0, "L", false, 0, "")
+	pdf.SetXY(4, 16)
+	pdf.CellFormat(50, 5, translate(strings.TrimSpace(firstName+" "+fatherName)), "", 0, "L", false, 0, "")
+
+	var qrPNG bytes.Buffer
+	png.Encode(&qrPNG, qrCode)
+	pdf.RegisterImageOptionsReader("qr", gofpdf.ImageOptions{ImageType: "PNG"}, &qrPNG)
+	pdf.ImageOptions("qr", 58, 4, 24, 24, false, gofpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+
+	// Штрихкод номера внизу карты
+	modules := bc.Bounds().Dx()
+	moduleWidth := 60.0 / float64(modules)
+	pdf.SetFillColor(0, 0, 0)
+	for m := 0; m < modules; m++ {
+		if isBar(bc, m) {
+			pdf.Rect(12.8+float64(m)*moduleWidth, 33, moduleWidth, 11, "F")
+		}
+	}
+	pdf.SetFont(fontFamily, "", 9)
+	pdf.SetXY(4, 45)
+	pdf.CellFormat(77.6, 5, number, "", 0, "C", false, 0, "")
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		log.Println("Ошибка формирования PDF:", err)
+		http.Error(w, "Error generating PDF", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="card-`+number+`.pdf"`)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"library-backend/db"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type MembershipPlan struct {
+	ID             int    `json:"id"`
+	Name           string `json:"name"`
+	DurationMonths int    `json:"duration_months"`
+	IsDefault      bool   `json:"is_default"`
+}
+
+type MembershipRenewal struct {
+	ID              int    `json:"id"`
+	PlanID          int    `json:"plan_id"`
+	PreviousExpires string `json:"previous_expires"`
+	NewExpires      string `json:"new_expires"`
+	RenewedBy       string `json:"renewed_by"`
+	RenewedAt       string `json:"renewed_at"`
+}
+
+type Membership struct {
+	ClientID int                 `json:"client_id"`
+	Start    string              `json:"start"`
+	Expires  string              `json:"expires"`
+	Active   bool                `json:"active"`
+	Renewals []MembershipRenewal `json:"renewals"`
+}
+
+type ExpiringMembership struct {
+	ClientID   int    `json:"client_id"`
+	FirstName  string `json:"first_name"`
+	LastName   string `json:"last_name"`
+	FatherName string `json:"father_name"`
+	Email      string `json:"email"`
+	Phone      string `json:"phone"`
+	Expires    string `json:"expires"`
+	DaysLeft   int    `json:"days_left"`
+}
+
+type RenewMembershipRequest struct {
+	PlanID int `json:"plan_id"`
+}
+
+// Вид членства по ID; при id = 0 возвращается вид по умолчанию
+func membershipPlanByID(q queryer, id int) (MembershipPlan, error) {
+	var plan MembershipPlan
+	query := "SELECT id, name, duration_months, is_default FROM membership_plans WHERE id = $1"
+	args := []any{id}
+	if id == 0 {
+		query = "SELECT id, name, duration_months, is_default FROM membership_plans WHERE is_default"
+		args = nil
+	}
+	err := q.QueryRow(query, args...).Scan(&plan.ID, &plan.Name, &plan.DurationMonths, &plan.IsDefault)
+	return plan, err
+}
+
+func GetMembershipPlans(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.DB.Query("SELECT id, name, duration_months, is_default FROM membership_plans ORDER BY duration_months, name")
+	if err != nil {
+		http.Error(w, "Error fetching membership plans", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var plans []MembershipPlan
+	for rows.Next() {
+		var plan MembershipPlan
+		if err := rows.Scan(&plan.ID, &plan.Name, &plan.DurationMonths, &plan.IsDefault); err != nil {
+			http.Error(w, "Error scanning membership plans", http.StatusInternalServerError)
+			return
+		}
+		plans = append(plans, plan)
+	}
+
+	json.NewEncoder(w).Encode(plans)
+}
+
+func validateMembershipPlan(plan MembershipPlan) []FieldError {
+	var errs []FieldError
+	if plan.Name == "" {
+		errs = append(errs, FieldError{"name", "is required"})
+	}
+	if plan.DurationMonths <= 0 || plan.DurationMonths > 120 {
+		errs = append(errs, FieldError{"duration_months", "must be between 1 and 120"})
+	}
+	return errs
+}
+
+// Сохранение вида членства; новый вид по умолчанию снимает отметку с прежнего
+func saveMembershipPlan(w http.ResponseWriter, plan MembershipPlan, id int) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if plan.IsDefault {
+		if _, err := tx.Exec("UPDATE membership_plans SET is_default = FALSE WHERE is_default AND id <> $1", id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if id == 0 {
+		err = tx.QueryRow("INSERT INTO membership_plans (name, duration_months, is_default) VALUES ($1, $2, $3) RETURNING id",
+			plan.Name, plan.DurationMonths, plan.IsDefault).Scan(&plan.ID)
+	} else {
+		plan.ID = id
+		var res sql.Result
+		res, err = tx.Exec("UPDATE membership_plans SET name = $1, duration_months = $2, is_default = $3 WHERE id = $4",
+			plan.Name, plan.DurationMonths, plan.IsDefault, id)
+		if err == nil {
+			if n, _ := res.RowsAffected(); n == 0 {
+				http.Error(w, "Membership plan not found", http.StatusNotFound)
+				return
+			}
+		}
+	}
+	if isUniqueViolation(err) {
+		writeValidationErrors(w, []FieldError{{"name", "is already used"}})
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if id == 0 {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(plan)
+}
+
+func AddMembershipPlan(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+
+	var plan MembershipPlan
+	if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if errs := validateMembershipPlan(plan); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	saveMembershipPlan(w, plan, 0)
+}
+
+func UpdateMembershipPlan(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid membership plan ID", http.StatusBadRequest)
+		return
+	}
+
+	var plan MembershipPlan
+	if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if errs := validateMembershipPlan(plan); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	saveMembershipPlan(w, plan, id)
+}
+
+// Срок членства клиента и история продлений
+func GetClientMembership(w http.ResponseWriter, r *http.Request) {
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	membership, err := loadMembership(db.DB, clientID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error fetching membership", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(membership)
+}
+
+// Членство клиента с историей продлений. Возвращает sql.ErrNoRows, если клиента нет.
+func loadMembership(q queryer, clientID int) (Membership, error) {
+	membership := Membership{ClientID: clientID, Renewals: []MembershipRenewal{}}
+	err := q.QueryRow(`
+        SELECT COALESCE(membership_start::text, ''), COALESCE(membership_expires::text, ''),
+               membership_expires IS NULL OR membership_expires >= CURRENT_DATE
+        FROM clients WHERE id = $1`, clientID).Scan(&membership.Start, &membership.Expires, &membership.Active)
+	if err != nil {
+		return membership, err
+	}
+
+	rows, err := q.Query(`
+        SELECT id, plan_id, COALESCE(previous_expires::text, ''), new_expires::text, COALESCE(renewed_by, ''), renewed_at::text
+        FROM membership_renewals
+        WHERE client_id = $1
+        ORDER BY renewed_at DESC`, clientID)
+	if err != nil {
+		return membership, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var renewal MembershipRenewal
+		if err := rows.Scan(&renewal.ID, &renewal.PlanID, &renewal.PreviousExpires, &renewal.NewExpires, &renewal.RenewedBy, &renewal.RenewedAt); err != nil {
+			return membership, err
+		}
+		membership.Renewals = append(membership.Renewals, renewal)
+	}
+	return membership, rows.Err()
+}
+
+// Продление членства. Действующее членство продлевается от даты окончания,
+// истекшее - от сегодняшнего дня.
+func RenewMembership(w http.ResponseWriter, r *http.Request) {
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	var request RenewMembershipRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	plan, err := membershipPlanByID(tx, request.PlanID)
+	if err == sql.ErrNoRows {
+		writeValidationErrors(w, []FieldError{{"plan_id", "unknown membership plan"}})
+		return
+	} else if err != nil {
+		http.Error(w, "Error fetching membership plan", http.StatusInternalServerError)
+		return
+	}
+
+	var previous sql.NullString
+	err = tx.QueryRow("SELECT membership_expires::text FROM clients WHERE id = $1 FOR UPDATE", clientID).Scan(&previous)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error fetching membership", http.StatusInternalServerError)
+		return
+	}
+
+	var renewal MembershipRenewal
+	err = tx.QueryRow(`
+        UPDATE clients SET
+            membership_start = CASE WHEN membership_expires IS NULL OR membership_expires < CURRENT_DATE
+                                    THEN CURRENT_DATE ELSE membership_start END,
+            membership_expires = GREATEST(membership_expires, CURRENT_DATE) + make_interval(months => $2)
+        WHERE id = $1
+        RETURNING membership_expires::text`, clientID, plan.DurationMonths).Scan(&renewal.NewExpires)
+	if err != nil {
+		log.Println("Ошибка продления членства:", err)
+		http.Error(w, "Error renewing membership", http.StatusInternalServerError)
+		return
+	}
+
+	renewal.PlanID = plan.ID
+	renewal.PreviousExpires = previous.String
+	renewal.RenewedBy = currentUsername(r)
+	err = tx.QueryRow(`
+        INSERT INTO membership_renewals (client_id, plan_id, previous_expires, new_expires, renewed_by)
+        VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+        RETURNING id, renewed_at::text`,
+		clientID, plan.ID, previous, renewal.NewExpires, renewal.RenewedBy).Scan(&renewal.ID, &renewal.RenewedAt)
+	if err != nil {
+		log.Println("Ошибка записи истории продления:", err)
+		http.Error(w, "Error renewing membership", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(renewal)
+}
+
+// Отчет: членство истекает в ближайшие ?days= дней (по умолчанию 30).
+// С ?include_expired=true в отчет попадают и уже истекшие.
+func GetExpiringMemberships(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		var err error
+		if days, err = strconv.Atoi(v); err != nil || days < 0 {
+			http.Error(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+	}
+	includeExpired := r.URL.Query().Get("include_expired") == "true"
+
+	rows, err := db.DB.Query(`
+        SELECT id, first_name, last_name, father_name, COALESCE(email, ''), COALESCE(phone, ''),
+               membership_expires::text, membership_expires - CURRENT_DATE
+        FROM clients
+        WHERE membership_expires <= CURRENT_DATE + $1::int
+          AND ($2 OR membership_expires >= CURRENT_DATE)
+        ORDER BY membership_expires, last_name, first_name`, days, includeExpired)
+	if err != nil {
+		http.Error(w, "Error fetching memberships", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var report []ExpiringMembership
+	for rows.Next() {
+		var m ExpiringMembership
+		if err := rows.Scan(&m.ClientID, &m.FirstName, &m.LastName, &m.FatherName, &m.Email, &m.Phone, &m.Expires, &m.DaysLeft); err != nil {
+			http.Error(w, "Error scanning memberships", http.StatusInternalServerError)
+			return
+		}
+		report = append(report, m)
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,402 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"library-backend/db"
+	"library-backend/utils"
+	"log"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+// Маршруты /patron доступны читателю только для его собственных данных.
+// ID читателя берется из токена (PatronAuthMiddleware), а не из запроса.
+
+var pinPattern = regexp.MustCompile(`^\d{4,6}$`)
+
+// После стольких неудачных попыток подряд вход блокируется на patronLockMinutes минут
+const (
+	patronMaxAttempts = 5
+	patronLockMinutes = 15
+)
+
+func patronClientID(r *http.Request) int {
+	clientID, _ := r.Context().Value("patron_client_id").(int)
+	return clientID
+}
+
+func PatronLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CardNumber string `json:"card_number"`
+		PIN        string `json:"pin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	// Одинаковый ответ для неизвестного билета и неверного PIN
+	clientID, err := clientIDByCard(req.CardNumber)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid card number or PIN", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "Error checking card", http.StatusInternalServerError)
+		return
+	}
+
+	var pinHash string
+	var locked bool
+	err = db.DB.QueryRow("SELECT pin_hash, COALESCE(locked_until > NOW(), FALSE) FROM patron_credentials WHERE client_id = $1", clientID).
+		Scan(&pinHash, &locked)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid card number or PIN", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "Error checking PIN", http.StatusInternalServerError)
+		return
+	}
+	if locked {
+		http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	if !utils.CheckPassword(pinHash, req.PIN) {
+		_, err := db.DB.Exec(`
+            UPDATE patron_credentials SET
+                failed_attempts = CASE WHEN failed_attempts + 1 >= $2 THEN 0 ELSE failed_attempts + 1 END,
+                locked_until = CASE WHEN failed_attempts + 1 >= $2 THEN NOW() + make_interval(mins => $3) ELSE locked_until END
+            WHERE client_id = $1`, clientID, patronMaxAttempts, patronLockMinutes)
+		if err != nil {
+			log.Println("Ошибка учета попытки входа читателя:", err)
+		}
+		http.Error(w, "Invalid card number or PIN", http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := db.DB.Exec("UPDATE patron_credentials SET failed_attempts = 0, locked_until = NULL WHERE client_id = $1", clientID); err != nil {
+		log.Println("Ошибка сброса попыток входа читателя:", err)
+	}
+
+	token, err := utils.GeneratePatronJWT(clientID)
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"token": token,
+	})
+}
+
+func savePIN(clientID int, pin string) error {
+	pinHash, err := utils.HashPassword(pin)
+	if err != nil {
+		return err
+	}
+	_, err = db.DB.Exec(`
+        INSERT INTO patron_credentials (client_id, pin_hash) VALUES ($1, $2)
+        ON CONFLICT (client_id) DO UPDATE SET pin_hash = EXCLUDED.pin_hash, failed_attempts = 0, locked_until = NULL, updated_at = NOW()`,
+		clientID, pinHash)
+	return err
+}
+
+// Установка PIN читателя библиотекарем. Без pin в запросе генерируется случайный
+// и возвращается в ответе один раз. PIN открывает доступ к данным читателя, а учетную
+// запись библиотекаря может завести любой через /register, поэтому нужна роль администратора.
+func SetPatronPIN(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		PIN string `json:"pin"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+	}
+
+	generated := req.PIN == ""
+	if generated {
+		n, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			http.Error(w, "Error generating PIN", http.StatusInternalServerError)
+			return
+		}
+		req.PIN = fmt.Sprintf("%04d", n.Int64())
+	} else if !pinPattern.MatchString(req.PIN) {
+		writeValidationErrors(w, []FieldError{{"pin", "must be 4-6 digits"}})
+		return
+	}
+
+	err = savePIN(clientID, req.PIN)
+	if isForeignKeyViolation(err) {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Ошибка сохранения PIN:", err)
+		http.Error(w, "Error saving PIN", http.StatusInternalServerError)
+		return
+	}
+
+	if generated {
+		json.NewEncoder(w).Encode(map[string]string{"pin": req.PIN})
+		return
+	}
+	w.Write([]byte("PIN updated successfully"))
+}
+
+// Смена PIN самим читателем
+func PatronChangePIN(w http.ResponseWriter, r *http.Request) {
+	clientID := patronClientID(r)
+
+	var req struct {
+		CurrentPIN string `json:"current_pin"`
+		NewPIN     string `json:"new_pin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if !pinPattern.MatchString(req.NewPIN) {
+		writeValidationErrors(w, []FieldError{{"new_pin", "must be 4-6 digits"}})
+		return
+	}
+
+	var pinHash string
+	err := db.DB.QueryRow("SELECT pin_hash FROM patron_credentials WHERE client_id = $1", clientID).Scan(&pinHash)
+	if err != nil || !utils.CheckPassword(pinHash, req.CurrentPIN) {
+		http.Error(w, "Current PIN is incorrect", http.StatusForbidden)
+		return
+	}
+
+	if err := savePIN(clientID, req.NewPIN); err != nil {
+		log.Println("Ошибка сохранения PIN:", err)
+		http.Error(w, "Error saving PIN", http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("PIN updated successfully"))
+}
+
+// Профиль читателя; паспортные данные всегда замаскированы
+func PatronProfile(w http.ResponseWriter, r *http.Request) {
+	clientID := patronClientID(r)
+
+	client, err := scanClient(db.DB.QueryRow("SELECT "+clientColumns+" FROM clients WHERE id = $1", clientID), false)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error fetching client", http.StatusInternalServerError)
+		return
+	}
+	client.Notifications, err = loadNotificationPrefs(db.DB, clientID)
+	if err != nil {
+		http.Error(w, "Error fetching notification preferences", http.StatusInternalServerError)
+		return
+	}
+
+	var historyOptIn bool
+	if err := db.DB.QueryRow("SELECT loan_history_opt_in FROM clients WHERE id = $1", clientID).Scan(&historyOptIn); err != nil {
+		http.Error(w, "Error fetching client", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Client
+		LoanHistoryOptIn bool `json:"loan_history_opt_in"`
+	}{client, historyOptIn})
+}
+
+// Текущие выдачи читателя со сроками возврата
+func PatronLoans(w http.ResponseWriter, r *http.Request) {
+	loans, err := loadClientLoans(db.DB, patronClientID(r), true)
+	if err != nil {
+		http.Error(w, "Error fetching loans", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(loans)
+}
+
+// История выдач доступна, только если читатель на нее согласился
+func PatronLoanHistory(w http.ResponseWriter, r *http.Request) {
+	clientID := patronClientID(r)
+
+	var optIn bool
+	if err := db.DB.QueryRow("SELECT loan_history_opt_in FROM clients WHERE id = $1", clientID).Scan(&optIn); err != nil {
+		http.Error(w, "Error fetching client", http.StatusInternalServerError)
+		return
+	}
+	if !optIn {
+		http.Error(w, "Loan history is disabled, enable it first", http.StatusForbidden)
+		return
+	}
+
+	loans, err := loadClientLoans(db.DB, clientID, false)
+	if err != nil {
+		http.Error(w, "Error fetching loans", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(loans)
+}
+
+func PatronSetHistoryPreference(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.DB.Exec("UPDATE clients SET loan_history_opt_in = $1 WHERE id = $2", req.Enabled, patronClientID(r)); err != nil {
+		http.Error(w, "Error saving preference", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(req)
+}
+
+// Штрафы читателя: сумма к оплате и выдачи, за которые начислен штраф
+func PatronFines(w http.ResponseWriter, r *http.Request) {
+	clientID := patronClientID(r)
+
+	outstanding, err := clientOutstandingFine(db.DB, clientID)
+	if err != nil {
+		http.Error(w, "Error fetching fines", http.StatusInternalServerError)
+		return
+	}
+
+	entries, err := loadFineEntries(db.DB, clientID)
+	if err != nil {
+		http.Error(w, "Error fetching fines", http.StatusInternalServerError)
+		return
+	}
+	receipts, err := loadClientReceipts(db.DB, clientID)
+	if err != nil {
+		http.Error(w, "Error fetching fines", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Outstanding decimal.Decimal `json:"outstanding"`
+		Entries     []FineEntry     `json:"entries"`
+		Receipts    []FineReceipt   `json:"receipts"`
+	}{outstanding, entries, receipts})
+}
+
+func PatronRenewLoan(w http.ResponseWriter, r *http.Request) {
+	journalID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid journal ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := renewLoan(tx, journalID, patronClientID(r), "")
+	if err != nil {
+		writeRequestError(w, err, "Error renewing loan")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+func PatronHolds(w http.ResponseWriter, r *http.Request) {
+	holds, err := loadClientHolds(db.DB, patronClientID(r), r.URL.Query().Get("all") != "true")
+	if err != nil {
+		http.Error(w, "Error fetching holds", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(holds)
+}
+
+func PatronPlaceHold(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		BookID int `json:"book_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.BookID == 0 {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	hold, err := placeHold(tx, patronClientID(r), req.BookID)
+	if err != nil {
+		writeRequestError(w, err, "Error placing hold")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hold)
+}
+
+func PatronCancelHold(w http.ResponseWriter, r *http.Request) {
+	holdID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid hold ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := cancelHold(tx, holdID, patronClientID(r)); err != nil {
+		writeRequestError(w, err, "Error cancelling hold")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Hold cancelled successfully"))
+}
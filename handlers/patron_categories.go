@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"library-backend/db"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+type PatronTypeLimit struct {
+	TypeID   int `json:"type_id"`
+	MaxLoans int `json:"max_loans"`
+}
+
+type PatronCategory struct {
+	ID                   int               `json:"id"`
+	Name                 string            `json:"name"`
+	MaxLoans             int               `json:"max_loans"`
+	LoanPeriodMultiplier float64           `json:"loan_period_multiplier"`
+	FineMultiplier       decimal.Decimal   `json:"fine_multiplier"`
+	IsDefault            bool              `json:"is_default"`
+	TypeLimits           []PatronTypeLimit `json:"type_limits"`
+}
+
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+const patronCategoryColumns = "id, name, max_loans, loan_period_multiplier, fine_multiplier, is_default"
+
+func scanPatronCategory(row rowScanner) (PatronCategory, error) {
+	var category PatronCategory
+	err := row.Scan(&category.ID, &category.Name, &category.MaxLoans, &category.LoanPeriodMultiplier, &category.FineMultiplier, &category.IsDefault)
+	return category, err
+}
+
+func loadTypeLimits(q queryer, category *PatronCategory) error {
+	category.TypeLimits = []PatronTypeLimit{}
+	rows, err := q.Query("SELECT type_id, max_loans FROM patron_category_type_limits WHERE category_id = $1 ORDER BY type_id", category.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var limit PatronTypeLimit
+		if err := rows.Scan(&limit.TypeID, &limit.MaxLoans); err != nil {
+			return err
+		}
+		category.TypeLimits = append(category.TypeLimits, limit)
+	}
+	return rows.Err()
+}
+
+// Категория клиента вместе с ограничениями по типам книг.
+// Возвращает sql.ErrNoRows, если клиента нет.
+func clientCategory(q queryer, clientID int) (PatronCategory, error) {
+	category, err := scanPatronCategory(q.QueryRow(`
+        SELECT pc.id, pc.name, pc.max_loans, pc.loan_period_multiplier, pc.fine_multiplier, pc.is_default
+        FROM clients c
+        JOIN patron_categories pc ON pc.id = c.category_id
+        WHERE c.id = $1`, clientID))
+	if err != nil {
+		return category, err
+	}
+	return category, loadTypeLimits(q, &category)
+}
+
+// Лимит категории для типа книги; ok = false, если отдельного лимита нет
+func (c PatronCategory) typeLimit(typeID int) (int, bool) {
+	for _, limit := range c.TypeLimits {
+		if limit.TypeID == typeID {
+			return limit.MaxLoans, true
+		}
+	}
+	return 0, false
+}
+
+// Срок выдачи в днях с учетом множителя категории
+func (c PatronCategory) loanDays(typeDays int) int {
+	return int(math.Round(float64(typeDays) * c.LoanPeriodMultiplier))
+}
+
+func isForeignKeyViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23503"
+}
+
+func GetPatronCategories(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.DB.Query("SELECT " + patronCategoryColumns + " FROM patron_categories ORDER BY name")
+	if err != nil {
+		http.Error(w, "Error fetching patron categories", http.StatusInternalServerError)
+		return
+	}
+
+	var categories []PatronCategory
+	for rows.Next() {
+		category, err := scanPatronCategory(rows)
+		if err != nil {
+			rows.Close()
+			http.Error(w, "Error scanning patron categories", http.StatusInternalServerError)
+			return
+		}
+		categories = append(categories, category)
+	}
+	rows.Close()
+
+	for i := range categories {
+		if err := loadTypeLimits(db.DB, &categories[i]); err != nil {
+			http.Error(w, "Error fetching type limits", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(categories)
+}
+
+func GetPatronCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	category, err := scanPatronCategory(db.DB.QueryRow("SELECT "+patronCategoryColumns+" FROM patron_categories WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Patron category not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error fetching patron category", http.StatusInternalServerError)
+		return
+	}
+	if err := loadTypeLimits(db.DB, &category); err != nil {
+		http.Error(w, "Error fetching type limits", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(category)
+}
+
+func validatePatronCategory(category PatronCategory) []FieldError {
+	var errs []FieldError
+	if category.Name == "" {
+		errs = append(errs, FieldError{"name", "is required"})
+	}
+	if category.MaxLoans < 0 {
+		errs = append(errs, FieldError{"max_loans", "must not be negative"})
+	}
+	if category.LoanPeriodMultiplier <= 0 || category.LoanPeriodMultiplier >= 100 {
+		errs = append(errs, FieldError{"loan_period_multiplier", "must be greater than 0 and less than 100"})
+	}
+	if category.FineMultiplier.IsNegative() || category.FineMultiplier.GreaterThanOrEqual(decimal.NewFromInt(100)) {
+		errs = append(errs, FieldError{"fine_multiplier", "must be between 0 and 100"})
+	}
+	seen := map[int]bool{}
+	for _, limit := range category.TypeLimits {
+		if limit.MaxLoans < 0 {
+			errs = append(errs, FieldError{"type_limits", "max_loans must not be negative"})
+		}
+		if seen[limit.TypeID] {
+			errs = append(errs, FieldError{"type_limits", "duplicate type " + strconv.Itoa(limit.TypeID)})
+		}
+		seen[limit.TypeID] = true
+	}
+	return errs
+}
+
+func decodePatronCategory(w http.ResponseWriter, r *http.Request) (PatronCategory, bool) {
+	// Множители по умолчанию - без изменений
+	category := PatronCategory{LoanPeriodMultiplier: 1, FineMultiplier: decimal.NewFromInt(1)}
+	if err := json.NewDecoder(r.Body).Decode(&category); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return category, false
+	}
+	if errs := validatePatronCategory(category); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return category, false
+	}
+	return category, true
+}
+
+// Сохранение категории вместе с лимитами по типам; лимиты заменяются целиком
+func savePatronCategory(w http.ResponseWriter, category PatronCategory, id int) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if category.IsDefault {
+		if _, err := tx.Exec("UPDATE patron_categories SET is_default = FALSE WHERE is_default AND id <> $1", id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if id == 0 {
+		err = tx.QueryRow(`
+            INSERT INTO patron_categories (name, max_loans, loan_period_multiplier, fine_multiplier, is_default)
+            VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+			category.Name, category.MaxLoans, category.LoanPeriodMultiplier, category.FineMultiplier, category.IsDefault).Scan(&category.ID)
+	} else {
+		category.ID = id
+		var res sql.Result
+		res, err = tx.Exec(`
+            UPDATE patron_categories
+            SET name = $1, max_loans = $2, loan_period_multiplier = $3, fine_multiplier = $4, is_default = $5
+            WHERE id = $6`,
+			category.Name, category.MaxLoans, category.LoanPeriodMultiplier, category.FineMultiplier, category.IsDefault, id)
+		if err == nil {
+			if n, _ := res.RowsAffected(); n == 0 {
+				http.Error(w, "Patron category not found", http.StatusNotFound)
+				return
+			}
+		}
+	}
+	if isUniqueViolation(err) {
+		writeValidationErrors(w, []FieldError{{"name", "is already used"}})
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM patron_category_type_limits WHERE category_id = $1", category.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, limit := range category.TypeLimits {
+		_, err := tx.Exec("INSERT INTO patron_category_type_limits (category_id, type_id, max_loans) VALUES ($1, $2, $3)",
+			category.ID, limit.TypeID, limit.MaxLoans)
+		if isForeignKeyViolation(err) {
+			writeValidationErrors(w, []FieldError{{"type_limits", "unknown book type " + strconv.Itoa(limit.TypeID)}})
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Без категории по умолчанию новых клиентов не записать
+	var hasDefault bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM patron_categories WHERE is_default)").Scan(&hasDefault); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !hasDefault {
+		writeValidationErrors(w, []FieldError{{"is_default", "another category must be made default first"}})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if category.TypeLimits == nil {
+		category.TypeLimits = []PatronTypeLimit{}
+	}
+	if id == 0 {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(category)
+}
+
+func AddPatronCategory(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+	category, ok := decodePatronCategory(w, r)
+	if !ok {
+		return
+	}
+	savePatronCategory(w, category, 0)
+}
+
+func UpdatePatronCategory(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+	category, ok := decodePatronCategory(w, r)
+	if !ok {
+		return
+	}
+	savePatronCategory(w, category, id)
+}
+
+// Удаление категории; категорию по умолчанию и назначенную клиентам удалить нельзя
+func DeletePatronCategory(w http.ResponseWriter, r *http.Request) {
+	if !requireRole(w, r, roleAdmin) {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	var isDefault bool
+	err = db.DB.QueryRow("SELECT is_default FROM patron_categories WHERE id = $1", id).Scan(&isDefault)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Patron category not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if isDefault {
+		http.Error(w, "Cannot delete the default category", http.StatusConflict)
+		return
+	}
+
+	_, err = db.DB.Exec("DELETE FROM patron_categories WHERE id = $1", id)
+	if isForeignKeyViolation(err) {
+		http.Error(w, "Category is assigned to clients", http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Println("Ошибка удаления категории читателей:", err)
+		http.Error(w, "Error deleting patron category", http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Patron category deleted successfully"))
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"encoding/json"
+	"library-backend/db"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Ключ advisory-блокировки, чтобы пересчет не выполнялся одновременно на нескольких репликах
+const recommendationLockKey = 29001
+
+// Сколько записей журнала обрабатывается за один проход
+const recommendationBatchSize = 1000
+
+type Recommendation struct {
+	BookID int    `json:"book_id"`
+	Name   string `json:"name"`
+	Score  int    `json:"score"`
+}
+
+// Запуск фонового пересчета матрицы совместных выдач
+func StartRecommendationJob(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := refreshRecommendations(); err != nil {
+				log.Println("Ошибка пересчета рекомендаций:", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Инкрементальный пересчет: обрабатываются только записи журнала после последней обработанной.
+// Пара (X, Y) увеличивается, когда читатель впервые берет X, а Y уже брал раньше,
+// так что счетчик равен числу разных читателей, бравших обе книги.
+func refreshRecommendations() (int, error) {
+	processed := 0
+	for {
+		n, err := refreshRecommendationsBatch()
+		processed += n
+		if err != nil || n < recommendationBatchSize {
+			return processed, err
+		}
+	}
+}
+
+func refreshRecommendationsBatch() (int, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRow("SELECT pg_try_advisory_xact_lock($1)", recommendationLockKey).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	var lastID int
+	if err := tx.QueryRow("SELECT last_journal_id FROM recommendation_state WHERE id = 1").Scan(&lastID); err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query("SELECT id, book_id, client_id FROM journal WHERE id > $1 ORDER BY id LIMIT $2", lastID, recommendationBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	type loan struct{ id, bookID, clientID int }
+	var loans []loan
+	for rows.Next() {
+		var l loan
+		if err := rows.Scan(&l.id, &l.bookID, &l.clientID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		loans = append(loans, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	upsert := `
+        INSERT INTO book_cooccurrence (book_id, other_book_id, clients)
+        SELECT $1, p.book_id, 1
+        FROM (SELECT DISTINCT book_id FROM journal WHERE client_id = $2 AND id < $3 AND book_id <> $1) p
+        ON CONFLICT (book_id, other_book_id) DO UPDATE SET clients = book_cooccurrence.clients + 1`
+	upsertReverse := `
+        INSERT INTO book_cooccurrence (book_id, other_book_id, clients)
+        SELECT p.book_id, $1, 1
+        FROM (SELECT DISTINCT book_id FROM journal WHERE client_id = $2 AND id < $3 AND book_id <> $1) p
+        ON CONFLICT (book_id, other_book_id) DO UPDATE SET clients = book_cooccurrence.clients + 1`
+
+	for _, l := range loans {
+		// Повторная выдача той же книги тому же читателю не добавляет новых пар
+		var borrowedBefore bool
+		err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM journal WHERE client_id = $1 AND book_id = $2 AND id < $3)",
+			l.clientID, l.bookID, l.id).Scan(&borrowedBefore)
+		if err != nil {
+			return 0, err
+		}
+		if borrowedBefore {
+			continue
+		}
+
+		if _, err := tx.Exec(upsert, l.bookID, l.clientID, l.id); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(upsertReverse, l.bookID, l.clientID, l.id); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(loans) > 0 {
+		lastID = loans[len(loans)-1].id
+	}
+	_, err = tx.Exec("UPDATE recommendation_state SET last_journal_id = $1, refreshed_at = NOW() WHERE id = 1", lastID)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(loans), tx.Commit()
+}
+
+// Полная перестройка матрицы, например после слияния дублей в каталоге
+func RebuildRecommendations(w http.ResponseWriter, r *http.Request) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", recommendationLockKey); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM book_cooccurrence"); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec("UPDATE recommendation_state SET last_journal_id = 0, refreshed_at = NULL WHERE id = 1"); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	processed, err := refreshRecommendations()
+	if err != nil {
+		log.Println("Ошибка пересчета рекомендаций:", err)
+		http.Error(w, "Error rebuilding recommendations", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Processed int `json:"processed"`
+	}{Processed: processed})
+}
+
+func recommendationLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 50 {
+		return 10
+	}
+	return limit
+}
+
+// "Читатели, бравшие эту книгу, также брали"
+func GetBookRecommendations(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+        SELECT b.id, b.name, c.clients
+        FROM book_cooccurrence c
+        JOIN books b ON b.id = c.other_book_id
+        WHERE c.book_id = $1
+        ORDER BY c.clients DESC, b.name
+        LIMIT $2`
+	rows, err := db.DB.Query(query, bookID, recommendationLimit(r))
+	if err != nil {
+		http.Error(w, "Error fetching recommendations", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var recommendations []Recommendation
+	for rows.Next() {
+		var rec Recommendation
+		if err := rows.Scan(&rec.BookID, &rec.Name, &rec.Score); err != nil {
+			http.Error(w, "Error scanning recommendations", http.StatusInternalServerError)
+			return
+		}
+		recommendations = append(recommendations, rec)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recommendations)
+}
+
+// Рекомендации читателю: суммарная совместная встречаемость с уже прочитанными им книгами
+func GetClientRecommendations(w http.ResponseWriter, r *http.Request) {
+	clientID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+        WITH borrowed AS (
+            SELECT DISTINCT book_id FROM journal WHERE client_id = $1
+        )
+        SELECT b.id, b.name, SUM(c.clients) AS score
+        FROM book_cooccurrence c
+        JOIN borrowed br ON br.book_id = c.book_id
+        JOIN books b ON b.id = c.other_book_id
+        WHERE c.other_book_id NOT IN (SELECT book_id FROM borrowed)
+        GROUP BY b.id, b.name
+        ORDER BY score DESC, b.name
+        LIMIT $2`
+	rows, err := db.DB.Query(query, clientID, recommendationLimit(r))
+	if err != nil {
+		http.Error(w, "Error fetching recommendations", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var recommendations []Recommendation
+	for rows.Next() {
+		var rec Recommendation
+		if err := rows.Scan(&rec.BookID, &rec.Name, &rec.Score); err != nil {
+			http.Error(w, "Error scanning recommendations", http.StatusInternalServerError)
+			return
+		}
+		recommendations = append(recommendations, rec)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recommendations)
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"library-backend/db"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+type RenewalResult struct {
+	JournalID    int    `json:"journal_id"`
+	OldDateEnd   string `json:"old_date_end"`
+	NewDateEnd   string `json:"new_date_end"`
+	RenewalCount int    `json:"renewal_count"`
+	MaxRenewals  int    `json:"max_renewals"`
+	// Штраф за просроченные до продления дни, начисленный в учет штрафов
+	FineCharged decimal.Decimal `json:"fine_charged"`
+}
+
+type LoanRenewal struct {
+	ID         int    `json:"id"`
+	JournalID  int    `json:"journal_id"`
+	OldDateEnd string `json:"old_date_end"`
+	NewDateEnd string `json:"new_date_end"`
+	RenewedBy  string `json:"renewed_by"`
+	RenewedAt  string `json:"renewed_at"`
+}
+
+// Продление выдачи: новый срок отсчитывается от сегодняшнего дня по сроку типа книги
+// с учетом категории читателя и переносится на ближайший рабочий день. Число продлений
+// и допустимая просрочка задаются типом книги, при наличии очереди на издание продление запрещено.
+// clientID > 0 ограничивает продление выдачами этого клиента; renewedBy пустой - продлил сам читатель.
+func renewLoan(tx *sql.Tx, journalID, clientID int, renewedBy string) (RenewalResult, error) {
+	result := RenewalResult{JournalID: journalID}
+
+	var loanClientID, bookID, typeDays, daysOverdue, overdueLimit int
+	var returned bool
+	err := tx.QueryRow(`
+        SELECT j.client_id, j.book_id, j.date_end::text, j.date_ret IS NOT NULL,
+               GREATEST(CURRENT_DATE - j.date_end::date, 0), j.renewal_count,
+               bt.day_count, bt.max_renewals, bt.renewal_overdue_days
+        FROM journal j
+        JOIN books b ON j.book_id = b.id
+        JOIN book_types bt ON b.type_id = bt.id
+        WHERE j.id = $1
+        FOR UPDATE OF j`, journalID).Scan(&loanClientID, &bookID, &result.OldDateEnd, &returned,
+		&daysOverdue, &result.RenewalCount, &typeDays, &result.MaxRenewals, &overdueLimit)
+	if err == sql.ErrNoRows || (err == nil && clientID > 0 && loanClientID != clientID) {
+		return result, rejectRequest(http.StatusNotFound, "Journal entry not found")
+	} else if err != nil {
+		return result, err
+	}
+	if returned {
+		return result, rejectRequest(http.StatusConflict, "Book is already returned")
+	}
+	if typeDays <= 0 {
+		return result, rejectRequest(http.StatusConflict, "Book type has no loan period")
+	}
+	if result.RenewalCount >= result.MaxRenewals {
+		return result, rejectRequest(http.StatusConflict, fmt.Sprintf("Renewal limit reached (%d)", result.MaxRenewals))
+	}
+	if daysOverdue > overdueLimit {
+		return result, rejectRequest(http.StatusConflict, fmt.Sprintf("Loan is overdue by %d days and cannot be renewed", daysOverdue))
+	}
+
+	// Издание ждут другие читатели
+	var queued bool
+	err = tx.QueryRow(`
+        SELECT EXISTS (SELECT 1 FROM holds WHERE book_id = $1 AND client_id <> $2 AND status = 'waiting')`,
+		bookID, loanClientID).Scan(&queued)
+	if err != nil {
+		return result, err
+	}
+	if queued {
+		return result, rejectRequest(http.StatusConflict, "Book has holds from other clients")
+	}
+
+	category, err := clientCategory(tx, loanClientID)
+	if err != nil {
+		return result, err
+	}
+
+	// Штраф за уже просроченные дни начисляется до продления: новый срок его бы обнулил
+	if daysOverdue > 0 {
+		policy, item, err := loanFineItem(tx, journalID)
+		if err != nil {
+			return result, err
+		}
+		if assessment := policy.Assess(item); assessment.Amount.IsPositive() {
+			result.FineCharged = assessment.Amount
+			if _, err := addFineEntry(tx, overdueFineEntry(loanClientID, journalID, assessment, renewedBy)); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	err = tx.QueryRow(`
+        UPDATE journal SET date_end = library_next_open_day(CURRENT_DATE + $2::int), renewal_count = renewal_count + 1
+        WHERE id = $1 AND library_next_open_day(CURRENT_DATE + $2::int) > date_end
+        RETURNING date_end::text, renewal_count`, journalID, category.loanDays(typeDays)).Scan(&result.NewDateEnd, &result.RenewalCount)
+	if err == sql.ErrNoRows {
+		return result, rejectRequest(http.StatusConflict, "Renewal would not extend the due date yet")
+	} else if err != nil {
+		return result, err
+	}
+
+	_, err = tx.Exec(`
+        INSERT INTO loan_renewals (journal_id, old_date_end, new_date_end, renewed_by)
+        VALUES ($1, $2, $3, NULLIF($4, ''))`, journalID, result.OldDateEnd, result.NewDateEnd, renewedBy)
+	if err != nil {
+		return result, err
+	}
+
+	// Набежавший штраф пересчитывается от нового срока; начисленное выше уже в учете штрафов
+	return result, accrueLoanFine(tx, journalID)
+}
+
+// Продление выдачи библиотекарем
+func RenewJournalEntry(w http.ResponseWriter, r *http.Request) {
+	journalID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid journal ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := renewLoan(tx, journalID, 0, currentUsername(r))
+	if err != nil {
+		writeRequestError(w, err, "Error renewing loan")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+// История продлений выдачи
+func GetLoanRenewals(w http.ResponseWriter, r *http.Request) {
+	journalID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid journal ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+        SELECT id, journal_id, old_date_end::text, new_date_end::text, COALESCE(renewed_by, ''), renewed_at::text
+        FROM loan_renewals
+        WHERE journal_id = $1
+        ORDER BY renewed_at`, journalID)
+	if err != nil {
+		http.Error(w, "Error fetching renewals", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	renewals := []LoanRenewal{}
+	for rows.Next() {
+		var renewal LoanRenewal
+		if err := rows.Scan(&renewal.ID, &renewal.JournalID, &renewal.OldDateEnd, &renewal.NewDateEnd, &renewal.RenewedBy, &renewal.RenewedAt); err != nil {
+			http.Error(w, "Error fetching renewals", http.StatusInternalServerError)
+			return
+		}
+		renewals = append(renewals, renewal)
+	}
+
+	json.NewEncoder(w).Encode(renewals)
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"library-backend/db"
 	"net/http"
+
+	"github.com/shopspring/decimal"
 )
 
 // Определение структуры для топ-книг
@@ -47,18 +49,25 @@ func GetTopBooks(w http.ResponseWriter, r *http.Request) {
 
 type ClientWithFine struct {
 	ClientName string `json:"client_name"`
-	TotalFine  int    `json:"total_fine"`
+	// Неоплаченный остаток вместе со штрафом, набежавшим по невозвращенным книгам
+	TotalFine   decimal.Decimal `json:"total_fine"`
+	AccruedFine decimal.Decimal `json:"accrued_fine"`
 }
 
 func GetTopClientsWithFines(w http.ResponseWriter, r *http.Request) {
 	query := `
         SELECT 
             c.last_name || ' ' || c.first_name AS client_name,
-            SUM(j.fine_today) AS total_fine
-        FROM journal j
-        JOIN clients c ON j.client_id = c.id
-        WHERE j.fine_today > 0
+            SUM(f.amount) AS total_fine,
+            SUM(f.accrued) AS accrued_fine
+        FROM (
+            SELECT client_id, amount, 0 AS accrued FROM fine_ledger
+            UNION ALL
+            SELECT client_id, fine_today, fine_today FROM journal WHERE date_ret IS NULL AND fine_today > 0
+        ) f
+        JOIN clients c ON f.client_id = c.id
         GROUP BY c.id, c.last_name, c.first_name
+        HAVING SUM(f.amount) > 0
         ORDER BY total_fine DESC;
     `
 
@@ -72,7 +81,7 @@ func GetTopClientsWithFines(w http.ResponseWriter, r *http.Request) {
 	var clients []ClientWithFine
 	for rows.Next() {
 		var client ClientWithFine
-		if err := rows.Scan(&client.ClientName, &client.TotalFine); err != nil {
+		if err := rows.Scan(&client.ClientName, &client.TotalFine, &client.AccruedFine); err != nil {
 			http.Error(w, "Error scanning clients with fines", http.StatusInternalServerError)
 			return
 		}
@@ -122,22 +131,30 @@ func GetClientFine(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var totalFine int
-	query := `
-		   SELECT SUM(fine_today)
-		   FROM journal
-		   WHERE client_id = $1`
-	err := db.DB.QueryRow(query, req.ClientID).Scan(&totalFine)
+	// Неоплаченный остаток, а не сумма всех когда-либо начисленных штрафов
+	outstanding, err := clientOutstandingFine(db.DB, req.ClientID)
+	if err != nil {
+		http.Error(w, "Error fetching client fine", http.StatusInternalServerError)
+		return
+	}
+
+	// Штраф, набежавший по невозвращенным книгам по последнему начислению
+	var accrued decimal.Decimal
+	err = db.DB.QueryRow("SELECT COALESCE(SUM(fine_today), 0) FROM journal WHERE client_id = $1 AND date_ret IS NULL", req.ClientID).Scan(&accrued)
 	if err != nil {
 		http.Error(w, "Error fetching client fine", http.StatusInternalServerError)
 		return
 	}
 
 	json.NewEncoder(w).Encode(struct {
-		ClientID  int `json:"client_id"`
-		TotalFine int `json:"total_fine"`
+		ClientID    int             `json:"client_id"`
+		TotalFine   decimal.Decimal `json:"total_fine"`
+		Outstanding decimal.Decimal `json:"outstanding"`
+		AccruedFine decimal.Decimal `json:"accrued_fine"`
 	}{
-		ClientID:  req.ClientID,
-		TotalFine: totalFine,
+		ClientID:    req.ClientID,
+		TotalFine:   outstanding.Add(accrued),
+		Outstanding: outstanding,
+		AccruedFine: accrued,
 	})
 }
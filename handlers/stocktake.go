@@ -0,0 +1,458 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"library-backend/db"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Сессия инвентаризации: проверяется либо диапазон полок, либо тип книг, либо и то и другое.
+// Шифры полок сравниваются без учета регистра, числа в шифре - по значению: A2 < A10 < B1
+// (см. shelf_sort_key).
+type StocktakeSession struct {
+	ID        int    `json:"id"`
+	TypeID    *int   `json:"type_id"`
+	ShelfFrom string `json:"shelf_from"`
+	ShelfTo   string `json:"shelf_to"`
+	Status    string `json:"status"`
+	OpenedAt  string `json:"opened_at"`
+	ClosedAt  string `json:"closed_at"`
+	Applied   bool   `json:"applied"`
+}
+
+type StocktakeCount struct {
+	BookID int `json:"book_id"`
+	Count  int `json:"count"`
+}
+
+type StocktakeItemsRequest struct {
+	Barcodes []string         `json:"barcodes"`
+	Counts   []StocktakeCount `json:"counts"`
+}
+
+// Строка отчета о расхождениях по одной книге
+type StocktakeDiscrepancy struct {
+	BookID      int    `json:"book_id"`
+	BookName    string `json:"name"`
+	Expected    int    `json:"expected"`
+	Counted     int    `json:"counted"`
+	OnLoan      int    `json:"on_loan"`
+	Missing     int    `json:"missing"`
+	Unexpected  int    `json:"unexpected"`
+	FoundOnLoan int    `json:"found_on_loan"`
+	InScope     bool   `json:"in_scope"`
+}
+
+type StocktakeReport struct {
+	Session       StocktakeSession       `json:"session"`
+	Discrepancies []StocktakeDiscrepancy `json:"discrepancies"`
+}
+
+const stocktakeSessionQuery = `
+        SELECT id, type_id, COALESCE(shelf_from, ''), COALESCE(shelf_to, ''), status,
+               opened_at, COALESCE(closed_at::text, ''), applied
+        FROM stocktake_sessions
+        WHERE id = $1`
+
+// Попадание книги b в область проверки сессии s. Книга без полки (или без типа)
+// в диапазон полок (или в тип) не попадает: shelf_sort_key от NULL и пустой строки
+// дает NULL, поэтому все условие сводится к FALSE, а не к NULL.
+const stocktakeScopeCond = `COALESCE(
+            (s.type_id IS NULL OR b.type_id = s.type_id)
+            AND (s.shelf_from IS NULL OR shelf_sort_key(b.shelf) >= shelf_sort_key(s.shelf_from))
+            AND (s.shelf_to IS NULL OR shelf_sort_key(b.shelf) <= shelf_sort_key(s.shelf_to)),
+            FALSE)`
+
+func scanStocktakeSession(row *sql.Row) (StocktakeSession, error) {
+	var s StocktakeSession
+	var typeID sql.NullInt64
+	err := row.Scan(&s.ID, &typeID, &s.ShelfFrom, &s.ShelfTo, &s.Status, &s.OpenedAt, &s.ClosedAt, &s.Applied)
+	if typeID.Valid {
+		id := int(typeID.Int64)
+		s.TypeID = &id
+	}
+	return s, err
+}
+
+func OpenStocktake(w http.ResponseWriter, r *http.Request) {
+	var session StocktakeSession
+	if err := json.NewDecoder(r.Body).Decode(&session); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if session.TypeID == nil && session.ShelfFrom == "" && session.ShelfTo == "" {
+		http.Error(w, "Either type_id or shelf range is required", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+        INSERT INTO stocktake_sessions (type_id, shelf_from, shelf_to)
+        VALUES ($1, NULLIF($2, ''), NULLIF($3, ''))
+        RETURNING id`
+	err := db.DB.QueryRow(query, session.TypeID, session.ShelfFrom, session.ShelfTo).Scan(&session.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	session, err = scanStocktakeSession(db.DB.QueryRow(stocktakeSessionQuery, session.ID))
+	if err != nil {
+		http.Error(w, "Error fetching stocktake session", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(session)
+}
+
+func GetStocktake(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid stocktake ID", http.StatusBadRequest)
+		return
+	}
+
+	session, err := scanStocktakeSession(db.DB.QueryRow(stocktakeSessionQuery, sessionID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Stocktake session not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error fetching stocktake session", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(session)
+}
+
+// Прием очередной партии отсканированных штрихкодов или ручных подсчетов.
+// Партии суммируются, поэтому одну полку можно сдавать несколькими запросами.
+func SubmitStocktakeItems(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid stocktake ID", http.StatusBadRequest)
+		return
+	}
+
+	var request StocktakeItemsRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow("SELECT status FROM stocktake_sessions WHERE id = $1 FOR UPDATE", sessionID).Scan(&status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Stocktake session not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if status != "open" {
+		http.Error(w, "Stocktake session is closed", http.StatusConflict)
+		return
+	}
+
+	counts := map[int]int{}
+	var unknown []string
+	for _, code := range request.Barcodes {
+		code = strings.TrimSpace(code)
+		bookID, err := resolveBarcode(tx, code)
+		if err == sql.ErrNoRows {
+			unknown = append(unknown, code)
+			continue
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		counts[bookID]++
+	}
+	for _, c := range request.Counts {
+		if c.Count < 0 {
+			http.Error(w, "Count cannot be negative", http.StatusBadRequest)
+			return
+		}
+		counts[c.BookID] += c.Count
+	}
+
+	query := `
+        INSERT INTO stocktake_counts (session_id, book_id, counted)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (session_id, book_id) DO UPDATE SET counted = stocktake_counts.counted + EXCLUDED.counted`
+	for bookID, cnt := range counts {
+		_, err := tx.Exec(query, sessionID, bookID, cnt)
+		if isForeignKeyViolation(err) {
+			writeValidationErrors(w, []FieldError{{"counts", fmt.Sprintf("book %d does not exist", bookID)}})
+			return
+		} else if err != nil {
+			log.Println("Ошибка сохранения подсчета:", err)
+			http.Error(w, "Error saving counts", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Accepted int      `json:"accepted"`
+		Unknown  []string `json:"unknown"`
+	}{
+		Accepted: len(request.Barcodes) - len(unknown),
+		Unknown:  unknown,
+	})
+}
+
+// Штрихкод ищется сначала по ISBN, затем как внутренний ID книги
+func resolveBarcode(tx *sql.Tx, code string) (int, error) {
+	var bookID int
+	err := tx.QueryRow("SELECT id FROM books WHERE isbn = $1 ORDER BY id LIMIT 1", code).Scan(&bookID)
+	if err != sql.ErrNoRows {
+		return bookID, err
+	}
+
+	id, convErr := strconv.Atoi(code)
+	if convErr != nil {
+		return 0, sql.ErrNoRows
+	}
+	err = tx.QueryRow("SELECT id FROM books WHERE id = $1", id).Scan(&bookID)
+	return bookID, err
+}
+
+// Закрытие сессии: фиксируем отчет о расхождениях.
+// Сам books.cnt не меняется, исправления применяются отдельно через ApplyStocktake.
+func CloseStocktake(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid stocktake ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow("SELECT status FROM stocktake_sessions WHERE id = $1 FOR UPDATE", sessionID).Scan(&status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Stocktake session not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if status != "open" {
+		http.Error(w, "Stocktake session is already closed", http.StatusConflict)
+		return
+	}
+
+	// В отчет попадают все книги из области проверки и все отсканированные книги вне ее
+	query := `
+        SELECT b.id, b.cnt, COALESCE(c.counted, 0),
+               (SELECT COUNT(*) FROM journal j WHERE j.book_id = b.id AND j.date_ret IS NULL),
+               ` + stocktakeScopeCond + ` AS in_scope
+        FROM books b
+        JOIN stocktake_sessions s ON s.id = $1
+        LEFT JOIN stocktake_counts c ON c.session_id = s.id AND c.book_id = b.id
+        WHERE c.book_id IS NOT NULL OR ` + stocktakeScopeCond
+	rows, err := tx.Query(query, sessionID)
+	if err != nil {
+		http.Error(w, "Error building stocktake report", http.StatusInternalServerError)
+		return
+	}
+
+	var discrepancies []StocktakeDiscrepancy
+	for rows.Next() {
+		var d StocktakeDiscrepancy
+		if err := rows.Scan(&d.BookID, &d.Expected, &d.Counted, &d.OnLoan, &d.InScope); err != nil {
+			rows.Close()
+			http.Error(w, "Error scanning stocktake report", http.StatusInternalServerError)
+			return
+		}
+		classifyDiscrepancy(&d)
+		if d.Missing > 0 || d.Unexpected > 0 || d.FoundOnLoan > 0 {
+			discrepancies = append(discrepancies, d)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	insert := `
+        INSERT INTO stocktake_discrepancies
+            (session_id, book_id, expected, counted, on_loan, missing, unexpected, found_on_loan, in_scope)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	for _, d := range discrepancies {
+		_, err := tx.Exec(insert, sessionID, d.BookID, d.Expected, d.Counted, d.OnLoan, d.Missing, d.Unexpected, d.FoundOnLoan, d.InScope)
+		if err != nil {
+			http.Error(w, "Error saving stocktake report", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	_, err = tx.Exec("UPDATE stocktake_sessions SET status = 'closed', closed_at = NOW() WHERE id = $1", sessionID)
+	if err != nil {
+		http.Error(w, "Error closing stocktake session", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeStocktakeReport(w, sessionID)
+}
+
+// Применение исправлений из отчета закрытой сессии к books.cnt
+func ApplyStocktake(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid stocktake ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	var applied bool
+	err = tx.QueryRow("SELECT status, applied FROM stocktake_sessions WHERE id = $1 FOR UPDATE", sessionID).Scan(&status, &applied)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Stocktake session not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if status != "closed" {
+		http.Error(w, "Stocktake session is still open", http.StatusConflict)
+		return
+	}
+	if applied {
+		http.Error(w, "Stocktake corrections are already applied", http.StatusConflict)
+		return
+	}
+
+	// Применяется разница, а не абсолютное значение, чтобы не потерять выдачи и возвраты
+	// после закрытия сессии. Книги, числящиеся на руках, но найденные на полке, не трогаем:
+	// их нужно провести возвратом через журнал. Книги вне области проверки считаются
+	// переставленными, а не лишними. Если после закрытия сессии часть экземпляров выдана,
+	// недостача может превысить остаток - тогда остаток обнуляется, а не уходит в минус.
+	_, err = tx.Exec(`
+        UPDATE books b SET cnt = GREATEST(b.cnt + d.unexpected - d.missing, 0)
+        FROM stocktake_discrepancies d
+        WHERE d.session_id = $1 AND d.book_id = b.id AND d.in_scope
+          AND d.unexpected <> d.missing`, sessionID)
+	if err != nil {
+		log.Println("Ошибка применения результатов инвентаризации:", err)
+		http.Error(w, "Error updating book count", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE stocktake_sessions SET applied = TRUE WHERE id = $1", sessionID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeStocktakeReport(w, sessionID)
+}
+
+// Разбор расхождения: недостача, лишние экземпляры и экземпляры, числящиеся выданными
+func classifyDiscrepancy(d *StocktakeDiscrepancy) {
+	if !d.InScope {
+		d.Unexpected = d.Counted
+		return
+	}
+
+	if d.Counted < d.Expected {
+		d.Missing = d.Expected - d.Counted
+		return
+	}
+
+	extra := d.Counted - d.Expected
+	d.FoundOnLoan = min(extra, d.OnLoan)
+	d.Unexpected = extra - d.FoundOnLoan
+}
+
+func GetStocktakeReport(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid stocktake ID", http.StatusBadRequest)
+		return
+	}
+
+	writeStocktakeReport(w, sessionID)
+}
+
+func writeStocktakeReport(w http.ResponseWriter, sessionID int) {
+	session, err := scanStocktakeSession(db.DB.QueryRow(stocktakeSessionQuery, sessionID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Stocktake session not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error fetching stocktake session", http.StatusInternalServerError)
+		return
+	}
+
+	if session.Status != "closed" {
+		http.Error(w, "Stocktake session is still open", http.StatusConflict)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+        SELECT d.book_id, b.name, d.expected, d.counted, d.on_loan, d.missing, d.unexpected, d.found_on_loan, d.in_scope
+        FROM stocktake_discrepancies d
+        JOIN books b ON d.book_id = b.id
+        WHERE d.session_id = $1
+        ORDER BY b.name`, sessionID)
+	if err != nil {
+		http.Error(w, "Error fetching stocktake report", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	report := StocktakeReport{Session: session}
+	for rows.Next() {
+		var d StocktakeDiscrepancy
+		err := rows.Scan(&d.BookID, &d.BookName, &d.Expected, &d.Counted, &d.OnLoan, &d.Missing, &d.Unexpected, &d.FoundOnLoan, &d.InScope)
+		if err != nil {
+			http.Error(w, "Error scanning stocktake report", http.StatusInternalServerError)
+			return
+		}
+		report.Discrepancies = append(report.Discrepancies, d)
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
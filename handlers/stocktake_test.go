@@ -0,0 +1,65 @@
+package handlers
+
+import "testing"
+
+func TestClassifyDiscrepancy(t *testing.T) {
+	tests := []struct {
+		name        string
+		d           StocktakeDiscrepancy
+		missing     int
+		unexpected  int
+		foundOnLoan int
+	}{
+		{
+			name: "counts match",
+			d:    StocktakeDiscrepancy{Expected: 3, Counted: 3, OnLoan: 1, InScope: true},
+		},
+		{
+			name:    "missing copies",
+			d:       StocktakeDiscrepancy{Expected: 5, Counted: 2, InScope: true},
+			missing: 3,
+		},
+		{
+			name:    "nothing found",
+			d:       StocktakeDiscrepancy{Expected: 4, Counted: 0, InScope: true},
+			missing: 4,
+		},
+		{
+			name:        "extra copies are on loan",
+			d:           StocktakeDiscrepancy{Expected: 2, Counted: 4, OnLoan: 3, InScope: true},
+			foundOnLoan: 2,
+		},
+		{
+			name:        "extra copies beyond loans",
+			d:           StocktakeDiscrepancy{Expected: 2, Counted: 6, OnLoan: 1, InScope: true},
+			unexpected:  3,
+			foundOnLoan: 1,
+		},
+		{
+			name:       "extra copies without loans",
+			d:          StocktakeDiscrepancy{Expected: 1, Counted: 3, InScope: true},
+			unexpected: 2,
+		},
+		{
+			// Книга без полки не входит в диапазон полок сессии: in_scope = FALSE
+			name:       "unshelved book scanned in shelf range",
+			d:          StocktakeDiscrepancy{Expected: 4, Counted: 1, OnLoan: 2, InScope: false},
+			unexpected: 1,
+		},
+		{
+			name: "out of scope and not scanned",
+			d:    StocktakeDiscrepancy{Expected: 4, Counted: 0, InScope: false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := tt.d
+			classifyDiscrepancy(&d)
+			if d.Missing != tt.missing || d.Unexpected != tt.unexpected || d.FoundOnLoan != tt.foundOnLoan {
+				t.Errorf("missing/unexpected/found_on_loan = %d/%d/%d, want %d/%d/%d",
+					d.Missing, d.Unexpected, d.FoundOnLoan, tt.missing, tt.unexpected, tt.foundOnLoan)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Ошибка валидации отдельного поля
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Ответ 422 со списком всех невалидных полей
+func writeValidationErrors(w http.ResponseWriter, errs []FieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(struct {
+		Errors []FieldError `json:"errors"`
+	}{Errors: errs})
+}
+
+// Отказ в операции, общей для служебных маршрутов и маршрутов читателя:
+// код ответа и сообщение для клиента
+type requestError struct {
+	Status  int
+	Message string
+}
+
+func (e *requestError) Error() string {
+	return e.Message
+}
+
+func rejectRequest(status int, message string) error {
+	return &requestError{Status: status, Message: message}
+}
+
+// Ответ по ошибке операции: отказ - со своим кодом, прочие ошибки - 500 с общим сообщением
+func writeRequestError(w http.ResponseWriter, err error, fallback string) {
+	if rejected, ok := err.(*requestError); ok {
+		http.Error(w, rejected.Message, rejected.Status)
+		return
+	}
+	log.Println(fallback+":", err)
+	http.Error(w, fallback, http.StatusInternalServerError)
+}
@@ -3,8 +3,11 @@ package main
 import (
 	"library-backend/db"
 	"library-backend/handlers"
+	"library-backend/middleware"
+	"library-backend/utils"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
@@ -15,6 +18,27 @@ func main() {
 	// Подключение к базе данных
 	db.Connect()
 
+	// Ключи подписи токенов библиотекарей и читателей
+	if err := utils.LoadJWTKeys(); err != nil {
+		log.Fatal("Cannot load JWT keys: ", err)
+	}
+
+	// Ключ шифрования паспортных данных
+	if err := utils.LoadPassportKey(); err != nil {
+		log.Fatal("Cannot load passport key: ", err)
+	}
+	if err := handlers.EncryptLegacyPassports(); err != nil {
+		log.Fatal("Cannot encrypt legacy passport data: ", err)
+	}
+	if err := handlers.BackfillClientSearch(); err != nil {
+		log.Fatal("Cannot fill client search fields: ", err)
+	}
+
+	// Фоновые задачи
+	handlers.StartRecommendationJob(15 * time.Minute)
+	handlers.StartHoldJob(time.Hour)
+	handlers.StartFineAccrualJob(time.Hour)
+
 	// Инициализация роутера
 	r := mux.NewRouter()
 
@@ -26,7 +50,46 @@ func main() {
 	r.HandleFunc("/clients/{id}", handlers.UpdateClient).Methods("PUT")
 	r.HandleFunc("/clients/{id}", handlers.DeleteClient).Methods("DELETE")
 	r.HandleFunc("/clients/all", handlers.GetAllClients).Methods("GET")
+	r.HandleFunc("/clients/search", handlers.SearchClients).Methods("GET")
+	r.HandleFunc("/clients/duplicates", handlers.GetClientDuplicates).Methods("GET")
+	r.HandleFunc("/clients/merge", handlers.MergeClients).Methods("POST")
+	r.HandleFunc("/clients/merges", handlers.GetClientMerges).Methods("GET")
 	r.HandleFunc("/clients/{id}", handlers.GetClientByID).Methods("GET")
+	r.HandleFunc("/clients/{id}/account", handlers.GetClientAccount).Methods("GET")
+
+	// Маршруты для читательских билетов
+	r.HandleFunc("/clients/{id}/cards", handlers.GetClientCards).Methods("GET")
+	r.HandleFunc("/clients/{id}/card", handlers.IssueLibraryCard).Methods("POST")
+	r.HandleFunc("/clients/{id}/card/replace", handlers.ReplaceLibraryCard).Methods("POST")
+	r.HandleFunc("/clients/{id}/card/print", handlers.PrintLibraryCard).Methods("GET")
+	r.HandleFunc("/cards/{number}", handlers.GetClientByCard).Methods("GET")
+
+	// Маршруты для членства
+	r.HandleFunc("/membership-plans", handlers.GetMembershipPlans).Methods("GET")
+	r.HandleFunc("/membership-plans", handlers.AddMembershipPlan).Methods("POST")
+	r.HandleFunc("/membership-plans/{id}", handlers.UpdateMembershipPlan).Methods("PUT")
+	r.HandleFunc("/clients/{id}/membership", handlers.GetClientMembership).Methods("GET")
+	r.HandleFunc("/clients/{id}/membership/renew", handlers.RenewMembership).Methods("POST")
+	r.HandleFunc("/reports/memberships-expiring", handlers.GetExpiringMemberships).Methods("GET")
+
+	// Маршруты для допуска к выдаче
+	r.HandleFunc("/eligibility-rules", handlers.GetEligibilityRules).Methods("GET")
+	r.HandleFunc("/eligibility-rules", handlers.UpdateEligibilityRules).Methods("PUT")
+	r.HandleFunc("/clients/{id}/eligibility", handlers.GetClientEligibility).Methods("GET")
+	r.HandleFunc("/clients/{id}/blocks", handlers.GetClientBlocks).Methods("GET")
+	r.HandleFunc("/clients/{id}/blocks", handlers.AddClientBlock).Methods("POST")
+	r.HandleFunc("/clients/{id}/blocks/{blockId}", handlers.LiftClientBlock).Methods("DELETE")
+
+	// Маршруты для запросов субъектов персональных данных
+	r.HandleFunc("/clients/{id}/export", handlers.ExportClientData).Methods("GET")
+	r.HandleFunc("/clients/{id}/anonymize", handlers.AnonymizeClient).Methods("POST")
+
+	// Маршруты для категорий читателей
+	r.HandleFunc("/patron-categories", handlers.GetPatronCategories).Methods("GET")
+	r.HandleFunc("/patron-categories", handlers.AddPatronCategory).Methods("POST")
+	r.HandleFunc("/patron-categories/{id}", handlers.GetPatronCategory).Methods("GET")
+	r.HandleFunc("/patron-categories/{id}", handlers.UpdatePatronCategory).Methods("PUT")
+	r.HandleFunc("/patron-categories/{id}", handlers.DeletePatronCategory).Methods("DELETE")
 
 	// Маршруты для книг
 	r.HandleFunc("/books", handlers.GetBooks).Methods("GET")
@@ -34,6 +97,9 @@ func main() {
 	r.HandleFunc("/books/{id}", handlers.UpdateBook).Methods("PUT")
 	r.HandleFunc("/books/{id}", handlers.DeleteBook).Methods("DELETE")
 	r.HandleFunc("/books/all", handlers.GetAllBooks).Methods("GET")
+	r.HandleFunc("/books/duplicates", handlers.GetBookDuplicates).Methods("GET")
+	r.HandleFunc("/books/merge", handlers.MergeBooks).Methods("POST")
+	r.HandleFunc("/books/merges", handlers.GetBookMerges).Methods("GET")
 	r.HandleFunc("/books/{id}", handlers.GetBookByID).Methods("GET")
 
 	// Маршруты для типов книг
@@ -48,17 +114,117 @@ func main() {
 	r.HandleFunc("/journal/return", handlers.ReturnBook).Methods("POST") // Прием книги
 	r.HandleFunc("/journal", handlers.GetJournalEntries).Methods("GET")  // Получение записей журнала
 	r.HandleFunc("/journal/fine", handlers.GetFine).Methods("POST")
+	r.HandleFunc("/journal/{id}/renew", handlers.RenewJournalEntry).Methods("POST")
+	r.HandleFunc("/journal/{id}/renewals", handlers.GetLoanRenewals).Methods("GET")
+	r.HandleFunc("/journal/{id}/lost", handlers.MarkLoanLost).Methods("POST")
+
+	// Маршруты для учета штрафов
+	r.HandleFunc("/clients/{id}/fines", handlers.GetClientFines).Methods("GET")
+	r.HandleFunc("/clients/{id}/payments", handlers.AddFinePayment).Methods("POST")
+	r.HandleFunc("/clients/{id}/waivers", handlers.WaiveFine).Methods("POST")
+	r.HandleFunc("/clients/{id}/receipts", handlers.GetClientReceipts).Methods("GET")
+	r.HandleFunc("/receipts/{id}", handlers.GetReceipt).Methods("GET")
+	r.HandleFunc("/journal/fines/recompute", handlers.RecomputeFines).Methods("POST")
+
+	// Маршруты для правил штрафов
+	r.HandleFunc("/fine-policies", handlers.GetFinePolicies).Methods("GET")
+	r.HandleFunc("/fine-policies", handlers.AddFinePolicy).Methods("POST")
+	r.HandleFunc("/fine-policies/{id}", handlers.GetFinePolicy).Methods("GET")
+	r.HandleFunc("/fine-policies/{id}", handlers.UpdateFinePolicy).Methods("PUT")
+	r.HandleFunc("/fine-policies/{id}", handlers.DeleteFinePolicy).Methods("DELETE")
+	r.HandleFunc("/fine-policies/{id}/preview", handlers.PreviewFinePolicy).Methods("GET")
+
+	// Маршруты для календаря библиотеки
+	r.HandleFunc("/calendar/hours", handlers.GetOpeningHours).Methods("GET")
+	r.HandleFunc("/calendar/hours", handlers.UpdateOpeningHours).Methods("PUT")
+	r.HandleFunc("/calendar/closures", handlers.GetCalendarClosures).Methods("GET")
+	r.HandleFunc("/calendar/closures", handlers.AddCalendarClosure).Methods("POST")
+	r.HandleFunc("/calendar/closures/{id}", handlers.DeleteCalendarClosure).Methods("DELETE")
+	r.HandleFunc("/calendar/days", handlers.GetCalendarDays).Methods("GET")
+	r.HandleFunc("/calendar/import", handlers.ImportCalendar).Methods("POST")
+
+	// Маршруты для резервов
+	r.HandleFunc("/holds", handlers.AddHold).Methods("POST")
+	r.HandleFunc("/holds/ready", handlers.GetReadyHolds).Methods("GET")
+	r.HandleFunc("/holds/{id}", handlers.CancelHold).Methods("DELETE")
+	r.HandleFunc("/books/{id}/holds", handlers.GetBookHolds).Methods("GET")
+	r.HandleFunc("/clients/{id}/holds", handlers.GetClientHolds).Methods("GET")
+
+	// Маршруты для читального зала
+	r.HandleFunc("/in-house/checkout", handlers.CheckoutInHouse).Methods("POST")
+	r.HandleFunc("/in-house/return", handlers.ReturnInHouse).Methods("POST")
+	r.HandleFunc("/in-house/open", handlers.GetOpenInHouseUses).Methods("GET")
+	r.HandleFunc("/reports/in-house-use", handlers.GetInHouseUseStats).Methods("GET")
 
 	r.HandleFunc("/reports/top-books", handlers.GetTopBooks).Methods("GET")
 	r.HandleFunc("/reports/top-clients-fines", handlers.GetTopClientsWithFines).Methods("GET")
 	r.HandleFunc("/reports/books-on-hand", handlers.GetBooksOnHand).Methods("POST")
 	r.HandleFunc("/reports/client-fine", handlers.GetClientFine).Methods("POST")
 
+	// Маршруты для инвентаризации
+	r.HandleFunc("/stocktake", handlers.OpenStocktake).Methods("POST")
+	r.HandleFunc("/stocktake/{id}", handlers.GetStocktake).Methods("GET")
+	r.HandleFunc("/stocktake/{id}/items", handlers.SubmitStocktakeItems).Methods("POST")
+	r.HandleFunc("/stocktake/{id}/close", handlers.CloseStocktake).Methods("POST")
+	r.HandleFunc("/stocktake/{id}/report", handlers.GetStocktakeReport).Methods("GET")
+	r.HandleFunc("/stocktake/{id}/apply", handlers.ApplyStocktake).Methods("POST")
+
+	// Маршруты для комплектования
+	r.HandleFunc("/vendors", handlers.GetVendors).Methods("GET")
+	r.HandleFunc("/vendors", handlers.AddVendor).Methods("POST")
+	r.HandleFunc("/vendors/{id}", handlers.UpdateVendor).Methods("PUT")
+	r.HandleFunc("/funds", handlers.GetFunds).Methods("GET")
+	r.HandleFunc("/funds", handlers.AddFund).Methods("POST")
+	r.HandleFunc("/funds/{id}", handlers.UpdateFund).Methods("PUT")
+	r.HandleFunc("/purchase-orders", handlers.GetPurchaseOrders).Methods("GET")
+	r.HandleFunc("/purchase-orders", handlers.AddPurchaseOrder).Methods("POST")
+	r.HandleFunc("/purchase-orders/{id}", handlers.GetPurchaseOrder).Methods("GET")
+	r.HandleFunc("/purchase-orders/{id}/receive", handlers.ReceivePurchaseOrder).Methods("POST")
+	r.HandleFunc("/reports/spend-by-fund", handlers.GetSpendByFund).Methods("GET")
+
+	// Маршруты для предметной классификации
+	r.HandleFunc("/classes", handlers.GetClasses).Methods("GET")
+	r.HandleFunc("/classes", handlers.AddClass).Methods("POST")
+	r.HandleFunc("/classes/import", handlers.ImportClasses).Methods("POST")
+	r.HandleFunc("/classes/{id}", handlers.GetClass).Methods("GET")
+	r.HandleFunc("/classes/{id}/children", handlers.GetClassChildren).Methods("GET")
+	r.HandleFunc("/classes/{id}/ancestors", handlers.GetClassAncestors).Methods("GET")
+	r.HandleFunc("/classes/{id}/books", handlers.GetClassBooks).Methods("GET")
+	r.HandleFunc("/books/{id}/classes", handlers.GetBookClasses).Methods("GET")
+	r.HandleFunc("/books/{id}/classes", handlers.SetBookClasses).Methods("PUT")
+	r.HandleFunc("/reports/books-by-subject", handlers.GetBooksBySubject).Methods("GET")
+
+	// Маршруты для рекомендаций
+	r.HandleFunc("/books/{id}/recommendations", handlers.GetBookRecommendations).Methods("GET")
+	r.HandleFunc("/clients/{id}/recommendations", handlers.GetClientRecommendations).Methods("GET")
+	r.HandleFunc("/recommendations/rebuild", handlers.RebuildRecommendations).Methods("POST")
+
+	// Маршруты для этикеток
+	r.HandleFunc("/labels/sizes", handlers.GetLabelSizes).Methods("GET")
+	r.HandleFunc("/labels/sheet", handlers.GetLabelSheet).Methods("POST")
+	r.HandleFunc("/books/{id}/label", handlers.GetBookLabel).Methods("GET")
+
+	// Маршруты самообслуживания читателей: отдельная аутентификация, только свои данные
+	r.HandleFunc("/clients/{id}/pin", handlers.SetPatronPIN).Methods("POST")
+	r.HandleFunc("/patron/login", handlers.PatronLogin).Methods("POST")
+	patron := r.PathPrefix("/patron").Subrouter()
+	patron.Use(middleware.PatronAuthMiddleware)
+	patron.HandleFunc("/me", handlers.PatronProfile).Methods("GET")
+	patron.HandleFunc("/pin", handlers.PatronChangePIN).Methods("PUT")
+	patron.HandleFunc("/loans", handlers.PatronLoans).Methods("GET")
+	patron.HandleFunc("/loans/{id}/renew", handlers.PatronRenewLoan).Methods("POST")
+	patron.HandleFunc("/history", handlers.PatronLoanHistory).Methods("GET")
+	patron.HandleFunc("/history", handlers.PatronSetHistoryPreference).Methods("PUT")
+	patron.HandleFunc("/fines", handlers.PatronFines).Methods("GET")
+	patron.HandleFunc("/holds", handlers.PatronHolds).Methods("GET")
+	patron.HandleFunc("/holds", handlers.PatronPlaceHold).Methods("POST")
+	patron.HandleFunc("/holds/{id}", handlers.PatronCancelHold).Methods("DELETE")
+
 	// Добавление CORS
 	c := cors.New(cors.Options{
 		AllowedOrigins: []string{"http://localhost:3000"}, // Разрешаем запросы с этого порта
 		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
-		AllowedHeaders: []string{"Content-Type"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
 	})
 
 	handler := c.Handler(r)
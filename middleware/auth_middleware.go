@@ -15,14 +15,15 @@ func AuthMiddleware(next http.Handler) http.Handler {
 		}
 
 		// Проверяем токен
-		username, err := utils.ValidateJWT(tokenString)
+		username, role, err := utils.ValidateJWTWithRole(tokenString)
 		if err != nil {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		// Передаем имя пользователя через контекст
+		// Передаем имя пользователя и роль через контекст
 		ctx := context.WithValue(r.Context(), "username", username)
+		ctx = context.WithValue(ctx, "role", role)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
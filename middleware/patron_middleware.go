@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"context"
+	"library-backend/utils"
+	"net/http"
+)
+
+// Проверка токена читателя для маршрутов /patron. Токены библиотекарей здесь не принимаются.
+func PatronAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString := r.Header.Get("Authorization")
+		if tokenString == "" {
+			http.Error(w, "Missing token", http.StatusUnauthorized)
+			return
+		}
+
+		clientID, err := utils.ValidatePatronJWT(tokenString)
+		if err != nil {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		// Передаем ID читателя через контекст
+		ctx := context.WithValue(r.Context(), "patron_client_id", clientID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+)
+
+// Ключ шифрования ключей (KEK) и производный от него ключ слепого индекса
+var (
+	kek      []byte
+	indexKey []byte
+)
+
+// Версия формата зашифрованных данных, первый байт шифротекста
+const envelopeVersion = 1
+
+// Загрузка KEK из файла PASSPORT_KEK_FILE или из переменной PASSPORT_KEK.
+// Ключ задается 64 hex-символами (32 байта).
+func LoadPassportKey() error {
+	value := os.Getenv("PASSPORT_KEK")
+	if path := os.Getenv("PASSPORT_KEK_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+	if value == "" {
+		return errors.New("PASSPORT_KEK_FILE or PASSPORT_KEK is not set")
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(key) != 32 {
+		return errors.New("passport key must be 32 bytes encoded as hex")
+	}
+
+	kek = key
+	mac := hmac.New(sha256.New, kek)
+	mac.Write([]byte("blind-index"))
+	indexKey = mac.Sum(nil)
+	return nil
+}
+
+// Новый ключ данных (DEK) и он же, зашифрованный KEK, для хранения рядом с записью
+func NewDataKey() (dek, wrapped []byte, err error) {
+	dek = make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err = Seal(kek, dek)
+	return dek, wrapped, err
+}
+
+func UnwrapDataKey(wrapped []byte) ([]byte, error) {
+	return Open(kek, wrapped)
+}
+
+// Шифрование AES-256-GCM, результат: версия || nonce || шифротекст
+func Seal(key, plaintext []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("encryption key is not loaded")
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{envelopeVersion}, nonce...)
+	return gcm.Seal(out, nonce, plaintext, nil), nil
+}
+
+func Open(key, sealed []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("encryption key is not loaded")
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < 1+gcm.NonceSize() || sealed[0] != envelopeVersion {
+		return nil, errors.New("invalid ciphertext")
+	}
+	nonce := sealed[1 : 1+gcm.NonceSize()]
+	return gcm.Open(nil, nonce, sealed[1+gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Слепой индекс: HMAC от значения, позволяет искать точные совпадения без расшифровки
+func BlindIndex(parts ...string) []byte {
+	mac := hmac.New(sha256.New, indexKey)
+	mac.Write([]byte(strings.Join(parts, "\x00")))
+	return mac.Sum(nil)
+}
@@ -1,6 +1,10 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -16,12 +20,56 @@ func CheckPassword(hash, password string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
 }
 
-var jwtKey = []byte("your_secret_key")
+// Ключ подписи токенов библиотекарей; задается при старте через LoadJWTKeys
+var jwtKey []byte
+
+// Минимальная длина ключа подписи HS256
+const minJWTKeyLength = 32
+
+// Чтение секрета из файла <name>_FILE или из переменной <name>
+func loadSecret(name string) ([]byte, error) {
+	value := os.Getenv(name)
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		value = string(data)
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, fmt.Errorf("%s_FILE or %s is not set", name, name)
+	}
+	if len(value) < minJWTKeyLength {
+		return nil, fmt.Errorf("%s must be at least %d bytes", name, minJWTKeyLength)
+	}
+	return []byte(value), nil
+}
+
+// Загрузка ключей подписи токенов: библиотекарей из JWT_SECRET_FILE или JWT_SECRET,
+// читателей из PATRON_JWT_SECRET_FILE или PATRON_JWT_SECRET. Без ключей сервер
+// не запускается: иначе токен с любой ролью или любым client_id мог бы подделать кто угодно.
+func LoadJWTKeys() error {
+	key, err := loadSecret("JWT_SECRET")
+	if err != nil {
+		return err
+	}
+	patronKey, err := loadSecret("PATRON_JWT_SECRET")
+	if err != nil {
+		return err
+	}
+	if string(key) == string(patronKey) {
+		return errors.New("PATRON_JWT_SECRET must differ from JWT_SECRET")
+	}
+	jwtKey, patronJWTKey = key, patronKey
+	return nil
+}
 
 // Генерация JWT токена
-func GenerateJWT(username string) (string, error) {
+func GenerateJWT(username, role string) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"username": username,
+		"role":     role,
 		"exp":      time.Now().Add(time.Hour * 24).Unix(), // Срок действия: 24 часа
 	})
 
@@ -29,15 +77,55 @@ func GenerateJWT(username string) (string, error) {
 }
 
 func ValidateJWT(tokenString string) (string, error) {
+	username, _, err := ValidateJWTWithRole(tokenString)
+	return username, err
+}
+
+// Проверка токена с извлечением роли; у токенов, выданных до появления ролей, роль пустая
+func ValidateJWTWithRole(tokenString string) (string, string, error) {
 	claims := &jwt.MapClaims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		return jwtKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+
+	if err != nil || !token.Valid {
+		return "", "", err
+	}
+
+	username, _ := (*claims)["username"].(string)
+	role, _ := (*claims)["role"].(string)
+	return username, role, nil
+}
+
+// Токены читателей подписываются отдельным ключом: токен читателя не принимается
+// служебными маршрутами, а токен библиотекаря - маршрутами читателя.
+var patronJWTKey []byte
+
+const patronAudience = "patron"
+
+func GeneratePatronJWT(clientID int) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"client_id": clientID,
+		"aud":       patronAudience,
+		"exp":       time.Now().Add(time.Hour * 2).Unix(), // Срок действия: 2 часа
 	})
 
+	return token.SignedString(patronJWTKey)
+}
+
+func ValidatePatronJWT(tokenString string) (int, error) {
+	claims := &jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return patronJWTKey, nil
+	}, jwt.WithAudience(patronAudience), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+
 	if err != nil || !token.Valid {
-		return "", err
+		return 0, errors.New("invalid patron token")
 	}
 
-	username := (*claims)["username"].(string)
-	return username, nil
+	clientID, ok := (*claims)["client_id"].(float64)
+	if !ok || clientID <= 0 {
+		return 0, errors.New("invalid patron token")
+	}
+	return int(clientID), nil
 }
@@ -0,0 +1,32 @@
+package utils
+
+// Контрольная цифра по алгоритму Луна
+func LuhnCheckDigit(digits string) byte {
+	sum := 0
+	double := true
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// Проверка номера, последняя цифра которого - контрольная
+func ValidLuhn(number string) bool {
+	if len(number) < 2 {
+		return false
+	}
+	for i := 0; i < len(number); i++ {
+		if number[i] < '0' || number[i] > '9' {
+			return false
+		}
+	}
+	return LuhnCheckDigit(number[:len(number)-1]) == number[len(number)-1]
+}
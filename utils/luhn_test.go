@@ -0,0 +1,69 @@
+package utils
+
+import "testing"
+
+func TestLuhnCheckDigit(t *testing.T) {
+	tests := []struct {
+		digits string
+		want   byte
+	}{
+		{"7992739871", '3'},
+		{"0", '0'},
+		{"1", '8'},
+		{"000000000", '0'},
+		{"453957876362148", '6'},
+	}
+
+	for _, tt := range tests {
+		if got := LuhnCheckDigit(tt.digits); got != tt.want {
+			t.Errorf("LuhnCheckDigit(%q) = %c, want %c", tt.digits, got, tt.want)
+		}
+	}
+}
+
+func TestValidLuhn(t *testing.T) {
+	tests := []struct {
+		number string
+		want   bool
+	}{
+		{"79927398713", true},
+		{"79927398710", false},
+		{"4539578763621486", true},
+		{"4539578763621487", false},
+		{"18", true},
+		{"00", true},
+		{"0", false},
+		{"", false},
+		{"7992739871A", false},
+		{"7992 7398713", false},
+		{"-79927398713", false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidLuhn(tt.number); got != tt.want {
+			t.Errorf("ValidLuhn(%q) = %v, want %v", tt.number, got, tt.want)
+		}
+	}
+}
+
+// Номер с дописанной контрольной цифрой проходит проверку, а замена любой
+// одной цифры обнаруживается
+func TestLuhnDetectsSingleDigitErrors(t *testing.T) {
+	for _, body := range []string{"7700000001", "7700012345", "7799999999"} {
+		number := body + string(LuhnCheckDigit(body))
+		if !ValidLuhn(number) {
+			t.Fatalf("ValidLuhn(%q) = false for generated number", number)
+		}
+		for i := range number {
+			for d := byte('0'); d <= '9'; d++ {
+				if d == number[i] {
+					continue
+				}
+				changed := number[:i] + string(d) + number[i+1:]
+				if ValidLuhn(changed) {
+					t.Errorf("ValidLuhn(%q) = true, changed digit %d of %q", changed, i, number)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package utils
+
+import "testing"
+
+func TestTransliterate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"Пушкин", "Pushkin"},
+		{"Щедрин", "Shchedrin"},
+		{"Хачатурян", "Khachaturyan"},
+		{"Цветаева Марина", "Tsvetaeva Marina"},
+		{"Юрий Жуков", "Yuriy Zhukov"},
+		{"Алёна Соловьёва", "Alena Soloveva"},
+		{"Подъезд", "Podezd"},
+		// Заглавной становится только первая буква буквосочетания
+		{"ЧЕХОВ", "ChEKhOV"},
+		{"Том 2, ч. 1", "Tom 2, ch. 1"},
+		{"Tolstoy", "Tolstoy"},
+	}
+
+	for _, tt := range tests {
+		if got := Transliterate(tt.in); got != tt.want {
+			t.Errorf("Transliterate(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}